@@ -0,0 +1,25 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Object is an opened blob, ready to be served with http.ServeContent.
+type Object interface {
+	io.ReadSeekCloser
+	Size() int64
+	ModTime() time.Time
+}
+
+// Storage keeps the raw content of file bins, addressed by key.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Append(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn with the key and modification time of every blob,
+	// stopping at the first error fn returns.
+	Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error
+}
@@ -0,0 +1,190 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type diskStorage struct {
+	root string
+}
+
+func NewDiskStorage(root string) (Storage, error) {
+	err := os.MkdirAll(root, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt create blob directory %s", root)
+	}
+
+	return &diskStorage{
+		root: root,
+	}, nil
+}
+
+// pathFor resolves a key inside the storage root and refuses keys
+// that would escape it.
+func (d *diskStorage) pathFor(key string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(key))
+	if clean == string(filepath.Separator) {
+		return "", errors.Errorf("invalid blob key %q", key)
+	}
+
+	return filepath.Join(d.root, strings.TrimPrefix(clean, string(filepath.Separator))), nil
+}
+
+// Put streams r into a temporary file and renames it into place once
+// fully written, so readers never see a partial blob.
+func (d *diskStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := d.pathFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldnt create directory for blob %s", key)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldnt create blob %s", key)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, errors.Wrapf(err, "couldnt write blob %s", key)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return n, errors.Wrapf(err, "couldnt write blob %s", key)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return n, errors.Wrapf(err, "couldnt store blob %s", key)
+	}
+
+	return n, nil
+}
+
+// Append writes r at the end of the blob, creating it if needed. Unlike
+// Put the bytes written before a failure are kept, which is what
+// resumable uploads rely on.
+func (d *diskStorage) Append(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := d.pathFor(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldnt create directory for blob %s", key)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldnt open blob %s", key)
+	}
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return n, errors.Wrapf(err, "couldnt append to blob %s", key)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return n, errors.Wrapf(err, "couldnt append to blob %s", key)
+	}
+
+	return n, nil
+}
+
+type diskObject struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (o *diskObject) Size() int64 {
+	return o.info.Size()
+}
+
+func (o *diskObject) ModTime() time.Time {
+	return o.info.ModTime()
+}
+
+func (d *diskStorage) Open(ctx context.Context, key string) (Object, error) {
+	path, err := d.pathFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt open blob %s", key)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "couldnt stat blob %s", key)
+	}
+
+	return &diskObject{File: f, info: info}, nil
+}
+
+func (d *diskStorage) Delete(ctx context.Context, key string) error {
+	path, err := d.pathFor(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "couldnt delete blob %s", key)
+	}
+
+	return nil
+}
+
+func (d *diskStorage) Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// blobs being written by Put are not blobs yet
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(rel), info.ModTime())
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldnt walk blobs")
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package client calls the HTTP API of a pastebin server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// Client calls the server at BaseURL, authenticated with Token when it
+// is set. EditToken is sent to change a bin created without an account.
+type Client struct {
+	BaseURL   string
+	Token     string
+	EditToken string
+	HTTP      *http.Client
+}
+
+// New returns a client of the server at baseURL, such as
+// http://localhost:4000.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// APIError is an error answered by the server.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return strconv.Itoa(e.Status) + " " + e.Message
+}
+
+// do sends the request and decodes the JSON answer into out, unless out
+// is nil.
+func (c *Client) do(req *http.Request, out interface{}) error {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.EditToken != "" {
+		req.Header.Set("X-Edit-Token", c.EditToken)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt call %s %s", req.Method, req.URL.Path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt decode answer of %s %s", req.Method, req.URL.Path)
+	}
+
+	return nil
+}
+
+// readError turns a failed response into an APIError. The server answers
+// either {"error": msg} or plain text.
+func readError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var payload struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		msg = payload.Error
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	return &APIError{Status: resp.StatusCode, Message: msg}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt build request %s %s", method, path)
+	}
+
+	return req, nil
+}
+
+// CreateBin creates a text bin holding bin.Contain.
+func (c *Client) CreateBin(ctx context.Context, bin store.Bin) (*store.Bin, error) {
+	nb := NewBin{
+		Alias:         bin.Alias,
+		Title:         bin.Title,
+		Visibility:    bin.Visibility,
+		Tags:          bin.Tags,
+		Language:      bin.Language,
+		Password:      bin.Password,
+		BurnAfterRead: bin.BurnAfterRead,
+		Text:          bin.Contain,
+	}
+	if !bin.ExpiresAt.IsZero() {
+		nb.Expiry = time.Until(bin.ExpiresAt)
+	}
+
+	return c.Upload(ctx, nb)
+}
+
+// GetAllBins returns the bins listed for the caller.
+func (c *Client) GetAllBins(ctx context.Context) ([]store.Bin, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/bins", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := []store.PublicBin{}
+	err = c.do(req, &pub)
+	if err != nil {
+		return nil, err
+	}
+
+	bins := make([]store.Bin, 0, len(pub))
+	for _, p := range pub {
+		bins = append(bins, binOf(p))
+	}
+
+	return bins, nil
+}
+
+// GetBinByAlias returns the bin with the given alias.
+func (c *Client) GetBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/bins/"+url.PathEscape(alias), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := store.PublicBin{}
+	err = c.do(req, &pub)
+	if err != nil {
+		return nil, err
+	}
+
+	bin := binOf(pub)
+	return &bin, nil
+}
+
+// UpdateBin applies patch to the bin with the given ID.
+func (c *Client) UpdateBin(ctx context.Context, id string, patch store.BinPatch) (*store.Bin, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt json marshal patch of bin %s", id)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, "/bins/"+url.PathEscape(id), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	pub := store.PublicBin{}
+	err = c.do(req, &pub)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := binOf(pub)
+	return &updated, nil
+}
+
+// binOf returns the bin the API showed as pub. The text of text bins is
+// back in Contain, the blob keys of files are not known to clients.
+func binOf(pub store.PublicBin) store.Bin {
+	bin := store.Bin{
+		ID:            pub.ID,
+		Alias:         pub.Alias,
+		Title:         pub.Title,
+		Contain:       pub.Content,
+		Filename:      pub.Filename,
+		ContentType:   pub.ContentType,
+		Size:          pub.Size,
+		Owner:         pub.Owner,
+		Visibility:    pub.Visibility,
+		Tags:          pub.Tags,
+		Language:      pub.Language,
+		BurnAfterRead: pub.BurnAfterRead,
+		Protected:     pub.Protected,
+		CreatedAt:     pub.CreatedAt,
+		UpdatedAt:     pub.UpdatedAt,
+		ExpiresAt:     pub.ExpiresAt,
+		EditToken:     pub.EditToken,
+	}
+	for _, f := range pub.Files {
+		bin.Files = append(bin.Files, store.BinFile{Name: f.Name, Size: f.Size, ContentType: f.ContentType})
+	}
+
+	return bin
+}
+
+// DeleteBinByID deletes the bin with the given ID and returns it.
+func (c *Client) DeleteBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/bins/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := store.PublicBin{}
+	err = c.do(req, &pub)
+	if err != nil {
+		return nil, err
+	}
+
+	bin := binOf(pub)
+	return &bin, nil
+}
+
+// GetStats returns the global statistics with the top most viewed bins.
+func (c *Client) GetStats(ctx context.Context, top int) (*store.Statistics, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/bins/statistics?top="+strconv.Itoa(top), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &store.Statistics{}
+	err = c.do(req, stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file of the command line client.
+type Config struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token"`
+}
+
+// DefaultConfigPath returns pastebin/client.yaml in the configuration
+// directory of the user, such as ~/.config on Linux.
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "pastebin", "client.yaml")
+}
+
+// LoadConfig reads the configuration file at path. A missing file is an
+// empty configuration unless required.
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt read client configuration %s", path)
+	}
+
+	err = yaml.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt parse client configuration %s", path)
+	}
+
+	return cfg, nil
+}
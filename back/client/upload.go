@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// File is a file to upload.
+type File struct {
+	Name    string
+	Content io.Reader
+}
+
+// NewBin describes a bin to upload, holding either Text or Files.
+type NewBin struct {
+	Alias         string
+	Title         string
+	Visibility    string
+	Tags          []string
+	Language      string
+	Password      string
+	Expiry        time.Duration
+	BurnAfterRead bool
+	Text          string
+	Files         []File
+}
+
+// fields lists the form fields of nb, without its content.
+func (nb NewBin) fields() [][2]string {
+	fields := [][2]string{
+		{"Alias", nb.Alias},
+		{"Title", nb.Title},
+		{"Visibility", nb.Visibility},
+		{"Tags", strings.Join(nb.Tags, ",")},
+		{"Language", nb.Language},
+		{"Password", nb.Password},
+	}
+	if nb.Expiry != 0 {
+		fields = append(fields, [2]string{"Expiry", nb.Expiry.String()})
+	}
+	if nb.BurnAfterRead {
+		fields = append(fields, [2]string{"BurnAfterRead", strconv.FormatBool(nb.BurnAfterRead)})
+	}
+
+	return fields
+}
+
+// Upload creates the bin nb. The files are streamed, not loaded in
+// memory.
+func (c *Client) Upload(ctx context.Context, nb NewBin) (*store.Bin, error) {
+	if (nb.Text == "") == (len(nb.Files) == 0) {
+		return nil, errors.New("a bin holds either text or files")
+	}
+
+	body, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeForm(form, nb))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/bins", body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	pub := store.PublicBin{}
+	err = c.do(req, &pub)
+	// stops writeForm when the server answered before reading it all
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	created := binOf(pub)
+	return &created, nil
+}
+
+func writeForm(form *multipart.Writer, nb NewBin) error {
+	for _, field := range nb.fields() {
+		if field[1] == "" {
+			continue
+		}
+
+		err := form.WriteField(field[0], field[1])
+		if err != nil {
+			return errors.Wrap(err, "couldnt write form")
+		}
+	}
+
+	if nb.Text != "" {
+		err := form.WriteField("Contain", nb.Text)
+		if err != nil {
+			return errors.Wrap(err, "couldnt write form")
+		}
+	}
+
+	for _, file := range nb.Files {
+		part, err := form.CreateFormFile("Contain", file.Name)
+		if err != nil {
+			return errors.Wrap(err, "couldnt write form")
+		}
+
+		_, err = io.Copy(part, file.Content)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt upload %s", file.Name)
+		}
+	}
+
+	return form.Close()
+}
+
+// URL returns the address where bin can be read: the raw text or file,
+// or an archive of the files of multi-file bins.
+func (c *Client) URL(bin *store.Bin) string {
+	if len(bin.Files) > 1 {
+		return c.BaseURL + "/bins/" + url.PathEscape(bin.Alias) + "/archive"
+	}
+
+	return c.BaseURL + "/bins/file/" + url.PathEscape(bin.Alias)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/config"
+	"pastebin/domain"
+	"pastebin/metrics"
+	"pastebin/search"
+	"pastebin/store"
+	"pastebin/tracing"
+)
+
+// backend is the Store, the blob storage and the search index of cfg,
+// wired together the way the server uses them.
+type backend struct {
+	svc     store.Store
+	blobs   blob.Storage
+	blobDir string
+	index   search.Index
+}
+
+func openBackend(ctx context.Context, cfg *config.Config) (*backend, error) {
+	secretsKey := cfg.Auth.TOTPKey
+	if secretsKey == "" {
+		secretsKey = cfg.Auth.JWTKey
+	}
+	svc, err := store.NewRedisDB(ctx, cfg.Redis.Addr, cfg.Bins.TTL, []byte(secretsKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt connect to redis")
+	}
+	svc = metrics.NewStore(svc)
+	svc = tracing.NewStore(svc)
+
+	blobDir := filepath.Clean(cfg.Storage.Dir)
+	blobs, err := blob.NewDiskStorage(blobDir)
+	if err != nil {
+		svc.Close()
+		return nil, errors.Wrap(err, "couldnt open blob storage")
+	}
+	blobs = tracing.NewStorage(blobs)
+
+	var index search.Index
+	switch cfg.Search.Index {
+	case "redis":
+		index, err = search.NewRedisIndex(ctx, cfg.Redis.Addr)
+		if err != nil {
+			svc.Close()
+			return nil, errors.Wrap(err, "couldnt open search index")
+		}
+	case "memory":
+		// the memory index starts empty at every run
+		index = search.NewMemoryIndex()
+		err = domain.Reindex(ctx, svc, index, blobs)
+		if err != nil {
+			svc.Close()
+			return nil, errors.Wrap(err, "couldnt fill search index")
+		}
+	default:
+		svc.Close()
+		return nil, errors.Errorf("unknown search index %s", cfg.Search.Index)
+	}
+
+	return &backend{
+		svc:     domain.NewIndexedStore(svc, index, blobs),
+		blobs:   blobs,
+		blobDir: blobDir,
+		index:   index,
+	}, nil
+}
+
+func (b *backend) Close() {
+	if err := b.index.Close(); err != nil {
+		slog.Error("couldnt close search index", "err", err)
+	}
+	if err := b.svc.Close(); err != nil {
+		slog.Error("couldnt close store", "err", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"pastebin/domain"
+	"pastebin/store"
+)
+
+// withBins runs action on the server given by --server or the client
+// configuration, or on the local Store when there is none.
+func withBins(action func(ctx context.Context, c *cli.Context, bins domain.Bins) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		ctx := context.Background()
+
+		remote, err := remoteClient(c)
+		if err != nil {
+			return err
+		}
+		if remote != nil {
+			remote.EditToken = c.String("edit-token")
+			return action(ctx, c, remote)
+		}
+
+		cfg, err := loadConfig(c.Command.Name, withConfigFile(c, nil))
+		if err != nil {
+			return err
+		}
+
+		b, err := openBackend(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+
+		return action(ctx, c, b.svc)
+	}
+}
+
+// withConfigFile puts the global --config flag in front of the settings
+// flags in args, which may still override it.
+func withConfigFile(c *cli.Context, args []string) []string {
+	file := c.GlobalString("config")
+	if file == "" {
+		return args
+	}
+
+	return append([]string{"-config", file}, args...)
+}
+
+// requireArgs checks the command got exactly n arguments, before any
+// connection is made.
+func requireArgs(n int) cli.BeforeFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != n {
+			return errors.Errorf("%s expects %d argument(s), usage: %s %s", c.Command.Name, n, c.Command.Name, c.Command.ArgsUsage)
+		}
+
+		return nil
+	}
+}
+
+// editTokenFlag gives the edit token of a bin created without an
+// account, to change it on a server.
+var editTokenFlag = cli.StringFlag{Name: "edit-token", Usage: "edit token given when the bin was created without an account"}
+
+func binCommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "create a text bin, reading the standard input without TEXT",
+			ArgsUsage: "[TEXT]",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "alias", Usage: "alias of the bin"},
+			},
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				text := c.Args().First()
+				if c.NArg() == 0 {
+					data, err := io.ReadAll(os.Stdin)
+					if err != nil {
+						return errors.Wrap(err, "couldnt read standard input")
+					}
+					text = string(data)
+				}
+
+				return domain.CreateBin(bins)(ctx, text, c.String("alias"))
+			}),
+		},
+		{
+			Name:      "get",
+			Usage:     "print a bin",
+			ArgsUsage: "ALIAS",
+			Before:    requireArgs(1),
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				return domain.GetBinByAlias(bins)(ctx, c.Args().First())
+			}),
+		},
+		{
+			Name:  "list",
+			Usage: "print every bin",
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				return domain.ListBins(bins)(ctx)
+			}),
+		},
+		{
+			Name:      "update",
+			Usage:     "change the alias or the content of a text bin, keeping the rest",
+			ArgsUsage: "ID",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "alias", Usage: "new alias of the bin"},
+				cli.StringFlag{Name: "contain", Usage: "new content of the bin"},
+				editTokenFlag,
+			},
+			Before: func(c *cli.Context) error {
+				if !c.IsSet("alias") && !c.IsSet("contain") {
+					return errors.New("update needs --alias or --contain")
+				}
+
+				return requireArgs(1)(c)
+			},
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				patch := store.BinPatch{}
+				if c.IsSet("alias") {
+					alias := c.String("alias")
+					patch.Alias = &alias
+				}
+				if c.IsSet("contain") {
+					contain := c.String("contain")
+					patch.Contain = &contain
+				}
+
+				return domain.UpdateBinByID(bins)(ctx, c.Args().First(), patch)
+			}),
+		},
+		{
+			Name:      "delete",
+			Usage:     "delete a bin",
+			ArgsUsage: "ID",
+			Flags:     []cli.Flag{editTokenFlag},
+			Before:    requireArgs(1),
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				return domain.DeleteBinByID(bins)(ctx, c.Args().First())
+			}),
+		},
+		{
+			Name:  "stats",
+			Usage: "print the global statistics",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "top", Value: 10, Usage: "number of most viewed bins listed"},
+			},
+			Action: withBins(func(ctx context.Context, c *cli.Context, bins domain.Bins) error {
+				return domain.GetStats(bins)(ctx, c.Int("top"))
+			}),
+		},
+	}
+}
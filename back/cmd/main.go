@@ -1,40 +1,74 @@
 package main
 
 import (
-	"context"
-	"flag"
 	"fmt"
-	"pastebin/domain"
-	"pastebin/store"
-	"math/rand"
-	"time"
-)
+	"os"
 
-func generateSecretKey() []byte {
-	rand.Seed(time.Now().UnixNano())
-	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890!@#$%^&*()-_=+"
-	b := make([]byte, 32)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return b
-}
+	"github.com/urfave/cli"
 
-func main() {
-	redis := flag.String("redis", "localhost:6379", "redis parameter")
-	flag.Parse()
+	"pastebin/client"
+)
 
-	svc, err := store.NewRedisDB(context.Background(), *redis)
-	if err != nil {
-		fmt.Printf("[error redis]: %v", err)
-		return
+func main() {
+	app := cli.NewApp()
+	app.Name = "pastebin"
+	app.Usage = "upload the standard input or files, serve bins, or manage them"
+	app.UsageText = "command | pastebin [options]\n   pastebin [options] FILE...\n   pastebin [global options] command [command options] [arguments...]"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "server",
+			Usage:  "URL of the server to talk to, such as http://localhost:4000 (default the local Store)",
+			EnvVar: "PASTEBIN_SERVER",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "bearer token sent to the server",
+			EnvVar: "PASTEBIN_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "client-config",
+			Value:  client.DefaultConfigPath(),
+			Usage:  "YAML file with the server and the token of the client",
+			EnvVar: "PASTEBIN_CLIENT_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "configuration file of the local Store",
+			EnvVar: "PASTEBIN_CONFIG",
+		},
 	}
+	app.Flags = append(app.Flags, uploadFlags...)
+	app.Commands = []cli.Command{
+		{
+			Name:            "serve",
+			Usage:           "serve the HTTP API, see serve -h for the settings",
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				return serve(withConfigFile(c, c.Args()))
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "inspect the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:            "print",
+					Usage:           "print the effective configuration, secrets redacted",
+					SkipFlagParsing: true,
+					Action: func(c *cli.Context) error {
+						return printConfig(withConfigFile(c, c.Args()))
+					},
+				},
+			},
+		},
+	}
+	app.Commands = append(app.Commands, binCommands()...)
+	// without a command, upload what is piped in or the files given
+	app.Action = upload
 
-	secretKey := generateSecretKey()
-
-	err = domain.ServeAPI(svc, secretKey)()
+	err := app.Run(os.Args)
 	if err != nil {
-		fmt.Printf("[error server]: %v", err)
-		return
+		fmt.Fprintf(os.Stderr, "[error]: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"pastebin/config"
+	"pastebin/domain"
+	"pastebin/health"
+	"pastebin/logging"
+	"pastebin/mailer"
+	"pastebin/metrics"
+	"pastebin/tracing"
+)
+
+// generateSecretKey returns a random key of 256 bits.
+func generateSecretKey() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt generate key")
+	}
+
+	return b, nil
+}
+
+// openMailer returns the mailer chosen by the configuration.
+func openMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	case "file":
+		return mailer.NewFileMailer(cfg.Dir, cfg.From)
+	}
+
+	return mailer.NewLogMailer(), nil
+}
+
+// loadConfig loads the configuration with the settings flags in args.
+func loadConfig(name string, args []string) (*config.Config, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	return loader.Load()
+}
+
+// printConfig prints the effective configuration of the flags in args,
+// with the secrets redacted.
+func printConfig(args []string) error {
+	cfg, err := loadConfig("config print", args)
+	if err != nil {
+		return err
+	}
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// serve runs the HTTP API with the settings flags in args until SIGINT or
+// SIGTERM, then drains it.
+func serve(args []string) error {
+	cfg, err := loadConfig("serve", args)
+	if err != nil {
+		return err
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: "pastebin",
+	})
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	policy, err := domain.ParseUploadPolicy(
+		strings.Join(cfg.Uploads.AllowedTypes, ","),
+		strings.Join(cfg.Uploads.DeniedTypes, ","),
+		strings.Join(cfg.Uploads.TypeSizeLimits, ","),
+	)
+	if err != nil {
+		return errors.Wrap(err, "invalid upload policy")
+	}
+
+	secretKey := []byte(cfg.Auth.JWTKey)
+	if len(secretKey) == 0 {
+		slog.Warn("no jwt key configured, using a random one, logins will not survive a restart")
+		secretKey, err = generateSecretKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	visitorKey := []byte(cfg.Bins.VisitorKey)
+	if len(visitorKey) == 0 {
+		slog.Warn("no visitor key configured, using a random one, visitors will be counted again after a restart")
+		visitorKey, err = generateSecretKey()
+		if err != nil {
+			return err
+		}
+	}
+
+	mail, err := openMailer(cfg.Mail)
+	if err != nil {
+		return err
+	}
+
+	b, err := openBackend(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	err = metrics.WatchBins(b.svc)
+	if err != nil {
+		return errors.Wrap(err, "couldnt register metrics")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		domain.RunJanitor(workers, b.svc, b.blobs, cfg.Uploads.JanitorInterval)
+	}()
+
+	checker := health.New(
+		health.Check{Name: "redis", Func: b.svc.Ping},
+		health.Check{Name: "blob_storage", Func: health.BlobWritable(b.blobs)},
+		health.Check{Name: "disk", Func: health.FreeDisk(b.blobDir, cfg.Storage.MinFreeDisk)},
+	)
+
+	err = domain.ServeAPI(b.svc, b.blobs, b.index, domain.Options{
+		SecretKey:            secretKey,
+		VisitorKey:           visitorKey,
+		MaxUploadSize:        cfg.Uploads.MaxSize,
+		UploadExpiry:         cfg.Uploads.Expiry,
+		Policy:               policy,
+		Health:               checker,
+		CORSOrigins:          cfg.Server.CORSOrigins,
+		TrustedProxies:       cfg.Server.TrustedProxies,
+		AccessTokenTTL:       cfg.Auth.AccessTokenTTL,
+		SessionTTL:           cfg.Auth.SessionTTL,
+		Admins:               cfg.Auth.Admins,
+		Mailer:               mail,
+		PublicURL:            cfg.Mail.PublicURL,
+		RequireVerifiedEmail: cfg.Auth.RequireVerifiedEmail,
+		VerifyTokenTTL:       cfg.Auth.VerifyTokenTTL,
+		ResetTokenTTL:        cfg.Auth.ResetTokenTTL,
+		TOTPIssuer:           cfg.Auth.TOTPIssuer,
+		RateLimit: domain.RateLimit{
+			Requests: cfg.Limits.Requests,
+			Window:   cfg.Limits.Window,
+		},
+		Quota: domain.Quota{
+			MaxBins:    cfg.Limits.MaxBinsByUser,
+			MaxBytes:   cfg.Limits.MaxBytesByUser,
+			MaxBinSize: cfg.Limits.MaxBinSize,
+		},
+		LoginProtection: domain.LoginProtection{
+			FreeAttempts:  cfg.Auth.LoginFreeAttempts,
+			MaxAttempts:   cfg.Auth.LoginMaxAttempts,
+			IPMaxAttempts: cfg.Auth.LoginIPMaxAttempts,
+			BaseDelay:     cfg.Auth.LoginBaseDelay,
+			Lockout:       cfg.Auth.LoginLockout,
+		},
+		PasswordPolicy: domain.PasswordPolicy{
+			MinLength:  int(cfg.Auth.PasswordMinLength),
+			MinClasses: int(cfg.Auth.PasswordMinClasses),
+		},
+		Server: domain.ServerOptions{
+			Addr:              cfg.Server.Addr,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			ReadTimeout:       cfg.Server.ReadTimeout,
+			WriteTimeout:      cfg.Server.WriteTimeout,
+			IdleTimeout:       cfg.Server.IdleTimeout,
+			DrainDelay:        cfg.Server.DrainDelay,
+			ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+		},
+	})(ctx)
+
+	// the requests are drained, nothing uses the workers anymore, the
+	// deferred calls then close the connections
+	stopWorkers()
+	wg.Wait()
+
+	return errors.Wrap(err, "server stopped")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"pastebin/client"
+	"pastebin/store"
+)
+
+// uploadFlags are the global flags of the uploader, the default action.
+var uploadFlags = []cli.Flag{
+	cli.StringFlag{Name: "alias, a", Usage: "alias of the bin (default generated by the server)"},
+	cli.StringFlag{Name: "title", Usage: "title of the bin"},
+	cli.StringFlag{Name: "tags", Usage: "comma separated tags of the bin"},
+	cli.DurationFlag{Name: "expiry, e", Usage: "delete the bin after this long, such as 1h (default the server maximum)"},
+	cli.StringFlag{Name: "visibility, v", Usage: "public, unlisted or private"},
+	cli.BoolFlag{Name: "burn, b", Usage: "delete the bin once it is read"},
+	cli.StringFlag{Name: "password, p", Usage: "password needed to read the bin", EnvVar: "PASTEBIN_PASSWORD"},
+	cli.StringFlag{Name: "language, l", Usage: "syntax of the text, such as go or python"},
+	cli.StringFlag{Name: "filename, f", Usage: "upload the standard input as a file with this name"},
+	cli.BoolFlag{Name: "json", Usage: "print the created bin as JSON, with its url"},
+	cli.IntFlag{Name: "copy", Usage: "also write the URL to this file descriptor, such as 3 for 3>&1 | xclip"},
+}
+
+// remoteClient returns the client of the server given by --server or the
+// client configuration file, or nil when there is none.
+func remoteClient(c *cli.Context) (*client.Client, error) {
+	path := c.GlobalString("client-config")
+	cfg, err := client.LoadConfig(path, c.GlobalIsSet("client-config"))
+	if err != nil {
+		return nil, err
+	}
+
+	server := cfg.Server
+	if c.GlobalIsSet("server") {
+		server = c.GlobalString("server")
+	}
+	token := cfg.Token
+	if c.GlobalIsSet("token") {
+		token = c.GlobalString("token")
+	}
+
+	if server == "" {
+		return nil, nil
+	}
+
+	return client.New(server, token), nil
+}
+
+// stdinIsTerminal reports whether nothing is piped to the standard input.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// upload sends the standard input, or the files given as arguments, as a
+// new bin and prints its URL:
+//
+//	make test 2>&1 | pastebin
+//	pastebin -e 1h --burn secret.txt
+func upload(c *cli.Context) error {
+	if c.NArg() == 0 && stdinIsTerminal() {
+		return cli.ShowAppHelp(c)
+	}
+
+	remote, err := remoteClient(c)
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		return errors.Errorf("no server to upload to, set --server, PASTEBIN_SERVER or server in %s", client.DefaultConfigPath())
+	}
+
+	nb := client.NewBin{
+		Alias:         c.GlobalString("alias"),
+		Title:         c.GlobalString("title"),
+		Visibility:    c.GlobalString("visibility"),
+		Language:      c.GlobalString("language"),
+		Password:      c.GlobalString("password"),
+		Expiry:        c.GlobalDuration("expiry"),
+		BurnAfterRead: c.GlobalBool("burn"),
+	}
+	if tags := c.GlobalString("tags"); tags != "" {
+		nb.Tags = strings.Split(tags, ",")
+	}
+
+	args := c.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+
+	for _, arg := range args {
+		if arg != "-" {
+			f, err := os.Open(arg)
+			if err != nil {
+				return errors.Wrapf(err, "couldnt open %s", arg)
+			}
+			defer f.Close()
+
+			nb.Files = append(nb.Files, client.File{Name: filepath.Base(arg), Content: f})
+			continue
+		}
+
+		name := c.GlobalString("filename")
+		// a lone text standard input makes a text bin
+		if name == "" && len(args) == 1 {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return errors.Wrap(err, "couldnt read standard input")
+			}
+			if len(data) == 0 {
+				return errors.New("nothing to upload, the standard input is empty")
+			}
+			if utf8.Valid(data) {
+				nb.Text = string(data)
+				continue
+			}
+			nb.Files = append(nb.Files, client.File{Name: "stdin", Content: strings.NewReader(string(data))})
+			continue
+		}
+
+		if name == "" {
+			name = "stdin"
+		}
+		nb.Files = append(nb.Files, client.File{Name: name, Content: os.Stdin})
+	}
+
+	bin, err := remote.Upload(context.Background(), nb)
+	if err != nil {
+		return err
+	}
+
+	return printUpload(c, remote.URL(bin), bin)
+}
+
+// printUpload prints the URL of bin, or bin as JSON, and copies the URL
+// to the --copy file descriptor.
+func printUpload(c *cli.Context, url string, bin *store.Bin) error {
+	if c.GlobalBool("json") {
+		out, err := json.MarshalIndent(struct {
+			URL string `json:"url"`
+			store.PublicBin
+		}{url, bin.Public()}, "", "    ")
+		if err != nil {
+			return errors.Wrap(err, "couldnt json marshal bin")
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(url)
+		if bin.EditToken != "" {
+			fmt.Fprintln(os.Stderr, "edit token:", bin.EditToken)
+		}
+	}
+
+	if fd := c.GlobalInt("copy"); fd > 0 {
+		f := os.NewFile(uintptr(fd), "copy")
+		if f == nil {
+			return errors.Errorf("invalid file descriptor %d", fd)
+		}
+
+		_, err := fmt.Fprintln(f, url)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt copy the URL to file descriptor %d", fd)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package config loads the settings of the service from defaults, a
+// YAML or TOML file, PASTEBIN_* environment variables and flags, each
+// source overriding the previous ones.
+package config
+
+import (
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EnvPrefix prefixes the env tag of every setting.
+const EnvPrefix = "PASTEBIN_"
+
+// Config is every setting of the service. The flag and env tags name the
+// flag and the environment variable (after EnvPrefix) of a setting, the
+// secret tag hides it when the configuration is printed.
+type Config struct {
+	Server  ServerConfig  `yaml:"server" toml:"server"`
+	Redis   RedisConfig   `yaml:"redis" toml:"redis"`
+	Storage StorageConfig `yaml:"storage" toml:"storage"`
+	Bins    BinsConfig    `yaml:"bins" toml:"bins"`
+	Uploads UploadsConfig `yaml:"uploads" toml:"uploads"`
+	Search  SearchConfig  `yaml:"search" toml:"search"`
+	Auth    AuthConfig    `yaml:"auth" toml:"auth"`
+	Limits  LimitsConfig  `yaml:"limits" toml:"limits"`
+	Mail    MailConfig    `yaml:"mail" toml:"mail"`
+	Log     LogConfig     `yaml:"log" toml:"log"`
+	Tracing TracingConfig `yaml:"tracing" toml:"tracing"`
+}
+
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" toml:"addr" flag:"addr" env:"ADDR" help:"address the HTTP server listens on"`
+	CORSOrigins       []string      `yaml:"cors_origins" toml:"cors_origins" flag:"cors-origins" env:"CORS_ORIGINS" help:"comma separated origins allowed to call the API from a browser"`
+	TrustedProxies    []string      `yaml:"trusted_proxies" toml:"trusted_proxies" flag:"trusted-proxies" env:"TRUSTED_PROXIES" help:"comma separated addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For header is trusted"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" flag:"read-header-timeout" env:"READ_HEADER_TIMEOUT" help:"how long reading the headers of a request may take"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" toml:"read_timeout" flag:"read-timeout" env:"READ_TIMEOUT" help:"how long reading a whole request, upload included, may take"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" toml:"write_timeout" flag:"write-timeout" env:"WRITE_TIMEOUT" help:"how long writing a response may take"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" toml:"idle_timeout" flag:"idle-timeout" env:"IDLE_TIMEOUT" help:"how long an idle keep-alive connection is kept"`
+	DrainDelay        time.Duration `yaml:"drain_delay" toml:"drain_delay" flag:"drain-delay" env:"DRAIN_DELAY" help:"how long to keep serving after reporting draining on shutdown"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" flag:"shutdown-timeout" env:"SHUTDOWN_TIMEOUT" help:"how long to wait for in-flight requests on shutdown"`
+}
+
+type RedisConfig struct {
+	Addr string `yaml:"addr" toml:"addr" flag:"redis" env:"REDIS_ADDR" help:"host:port of the Redis server"`
+}
+
+type StorageConfig struct {
+	Dir         string `yaml:"dir" toml:"dir" flag:"upload-dir" env:"UPLOAD_DIR" help:"directory where uploaded files are kept"`
+	MinFreeDisk uint64 `yaml:"min_free_disk" toml:"min_free_disk" flag:"min-free-disk" env:"MIN_FREE_DISK" help:"free bytes under which the upload disk is reported not ready"`
+}
+
+type BinsConfig struct {
+	TTL        time.Duration `yaml:"ttl" toml:"ttl" flag:"bin-ttl" env:"BIN_TTL" help:"how long a bin is kept"`
+	VisitorKey string        `yaml:"visitor_key" toml:"visitor_key" flag:"visitor-key" env:"VISITOR_KEY" secret:"true" help:"key hashing the addresses of the visitors counted in the statistics of bins (default random, visitors are counted again after a restart)"`
+}
+
+type UploadsConfig struct {
+	MaxSize         int64         `yaml:"max_size" toml:"max_size" flag:"max-upload-size" env:"MAX_UPLOAD_SIZE" help:"maximum size in bytes of an upload"`
+	Expiry          time.Duration `yaml:"expiry" toml:"expiry" flag:"upload-expiry" env:"UPLOAD_EXPIRY" help:"how long an unfinished resumable upload is kept"`
+	JanitorInterval time.Duration `yaml:"janitor_interval" toml:"janitor_interval" flag:"janitor-interval" env:"JANITOR_INTERVAL" help:"how often expired resumable uploads are removed"`
+	AllowedTypes    []string      `yaml:"allowed_types" toml:"allowed_types" flag:"allowed-types" env:"ALLOWED_TYPES" help:"comma separated content types accepted for uploads, such as image/*,text/plain (default all)"`
+	DeniedTypes     []string      `yaml:"denied_types" toml:"denied_types" flag:"denied-types" env:"DENIED_TYPES" help:"comma separated content types refused for uploads"`
+	TypeSizeLimits  []string      `yaml:"type_size_limits" toml:"type_size_limits" flag:"type-size-limits" env:"TYPE_SIZE_LIMITS" help:"comma separated per content type size caps in bytes, such as image/*=5242880"`
+}
+
+type SearchConfig struct {
+	Index string `yaml:"index" toml:"index" flag:"search-index" env:"SEARCH_INDEX" help:"where the search index is kept, redis or memory"`
+}
+
+type AuthConfig struct {
+	JWTKey               string        `yaml:"jwt_key" toml:"jwt_key" flag:"jwt-key" env:"JWT_KEY" secret:"true" help:"key signing the login tokens (default random, tokens do not survive a restart)"`
+	AccessTokenTTL       time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl" flag:"access-token-ttl" env:"ACCESS_TOKEN_TTL" help:"lifetime of the access tokens issued at login"`
+	SessionTTL           time.Duration `yaml:"session_ttl" toml:"session_ttl" flag:"session-ttl" env:"SESSION_TTL" help:"lifetime of a login session, refreshed or not"`
+	PasswordMinLength    int64         `yaml:"password_min_length" toml:"password_min_length" flag:"password-min-length" env:"PASSWORD_MIN_LENGTH" help:"fewest characters of a new password"`
+	PasswordMinClasses   int64         `yaml:"password_min_classes" toml:"password_min_classes" flag:"password-min-classes" env:"PASSWORD_MIN_CLASSES" help:"how many of lowercase, uppercase, digits and symbols a new password must mix, 0 to 4"`
+	LoginFreeAttempts    int64         `yaml:"login_free_attempts" toml:"login_free_attempts" flag:"login-free-attempts" env:"LOGIN_FREE_ATTEMPTS" help:"failed logins of an IP or an account before the next ones are delayed"`
+	LoginMaxAttempts     int64         `yaml:"login_max_attempts" toml:"login_max_attempts" flag:"login-max-attempts" env:"LOGIN_MAX_ATTEMPTS" help:"failed logins locking an account out"`
+	LoginIPMaxAttempts   int64         `yaml:"login_ip_max_attempts" toml:"login_ip_max_attempts" flag:"login-ip-max-attempts" env:"LOGIN_IP_MAX_ATTEMPTS" help:"failed logins locking an IP out"`
+	LoginBaseDelay       time.Duration `yaml:"login_base_delay" toml:"login_base_delay" flag:"login-base-delay" env:"LOGIN_BASE_DELAY" help:"first delay after the free attempts, doubled at each failure"`
+	LoginLockout         time.Duration `yaml:"login_lockout" toml:"login_lockout" flag:"login-lockout" env:"LOGIN_LOCKOUT" help:"how long a lockout lasts, and failures are remembered"`
+	RequireVerifiedEmail bool          `yaml:"require_verified_email" toml:"require_verified_email" flag:"require-verified-email" env:"REQUIRE_VERIFIED_EMAIL" help:"refuse logins until the email of the user is verified"`
+	VerifyTokenTTL       time.Duration `yaml:"verify_token_ttl" toml:"verify_token_ttl" flag:"verify-token-ttl" env:"VERIFY_TOKEN_TTL" help:"lifetime of the mailed email verification links"`
+	ResetTokenTTL        time.Duration `yaml:"reset_token_ttl" toml:"reset_token_ttl" flag:"reset-token-ttl" env:"RESET_TOKEN_TTL" help:"lifetime of the mailed password reset links"`
+	TOTPKey              string        `yaml:"totp_key" toml:"totp_key" flag:"totp-key" env:"TOTP_KEY" secret:"true" help:"key encrypting the two-factor secrets of the users (default auth.jwt_key, two-factor is off without either)"`
+	TOTPIssuer           string        `yaml:"totp_issuer" toml:"totp_issuer" flag:"totp-issuer" env:"TOTP_ISSUER" help:"name of the service shown by authenticator apps"`
+	Admins               []string      `yaml:"admins" toml:"admins" flag:"admins" env:"ADMINS" help:"comma separated emails of the users given the admin role"`
+}
+
+type LimitsConfig struct {
+	Requests       int64         `yaml:"requests" toml:"requests" flag:"rate-limit" env:"RATE_LIMIT" help:"requests each IP, user or API token may send per rate limit window, 0 for no limit"`
+	Window         time.Duration `yaml:"window" toml:"window" flag:"rate-limit-window" env:"RATE_LIMIT_WINDOW" help:"window of the rate limit"`
+	MaxBinsByUser  int64         `yaml:"max_bins_by_user" toml:"max_bins_by_user" flag:"quota-bins" env:"QUOTA_BINS" help:"bins each user may keep, 0 for no limit"`
+	MaxBytesByUser int64         `yaml:"max_bytes_by_user" toml:"max_bytes_by_user" flag:"quota-bytes" env:"QUOTA_BYTES" help:"bytes each user may store in all their bins, 0 for no limit"`
+	MaxBinSize     int64         `yaml:"max_bin_size" toml:"max_bin_size" flag:"quota-bin-size" env:"QUOTA_BIN_SIZE" help:"bytes a bin of a user may hold, 0 for the upload limit only"`
+}
+
+type MailConfig struct {
+	Driver       string `yaml:"driver" toml:"driver" flag:"mail-driver" env:"MAIL_DRIVER" help:"how emails are sent, smtp, file or log"`
+	From         string `yaml:"from" toml:"from" flag:"mail-from" env:"MAIL_FROM" help:"sender address of the emails"`
+	PublicURL    string `yaml:"public_url" toml:"public_url" flag:"public-url" env:"PUBLIC_URL" help:"URL of the front end the mailed links point to"`
+	SMTPAddr     string `yaml:"smtp_addr" toml:"smtp_addr" flag:"smtp-addr" env:"SMTP_ADDR" help:"host:port of the SMTP server"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" flag:"smtp-username" env:"SMTP_USERNAME" help:"SMTP user name, empty for no authentication"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" flag:"smtp-password" env:"SMTP_PASSWORD" secret:"true" help:"SMTP password"`
+	Dir          string `yaml:"dir" toml:"dir" flag:"mail-dir" env:"MAIL_DIR" help:"directory the file driver writes emails to"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level" flag:"log-level" env:"LOG_LEVEL" help:"minimum level of the logs, debug, info, warn or error"`
+	Format string `yaml:"format" toml:"format" flag:"log-format" env:"LOG_FORMAT" help:"format of the logs, text or json"`
+}
+
+type TracingConfig struct {
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" flag:"otlp-endpoint" env:"OTLP_ENDPOINT" help:"host:port of the OTLP/HTTP trace collector (default tracing disabled)"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" flag:"otlp-insecure" env:"OTLP_INSECURE" help:"send traces to the collector over plain HTTP"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" flag:"trace-sample-ratio" env:"TRACE_SAMPLE_RATIO" help:"share of the new traces recorded, from 0 to 1"`
+}
+
+// Default returns the settings used when no source sets them.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":4000",
+			CORSOrigins:       []string{"http://localhost:8080"},
+			TrustedProxies:    []string{},
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       5 * time.Minute,
+			WriteTimeout:      5 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Redis: RedisConfig{
+			Addr: "localhost:6379",
+		},
+		Storage: StorageConfig{
+			Dir:         "./files",
+			MinFreeDisk: 100 << 20,
+		},
+		Bins: BinsConfig{
+			TTL: 30 * 24 * time.Hour,
+		},
+		Uploads: UploadsConfig{
+			MaxSize:         32 << 20,
+			Expiry:          24 * time.Hour,
+			JanitorInterval: 10 * time.Minute,
+			AllowedTypes:    []string{},
+			DeniedTypes:     []string{},
+			TypeSizeLimits:  []string{},
+		},
+		Search: SearchConfig{
+			Index: "redis",
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:     15 * time.Minute,
+			SessionTTL:         30 * 24 * time.Hour,
+			Admins:             []string{},
+			PasswordMinLength:  8,
+			PasswordMinClasses: 1,
+			LoginFreeAttempts:  3,
+			LoginMaxAttempts:   10,
+			LoginIPMaxAttempts: 100,
+			LoginBaseDelay:     time.Second,
+			LoginLockout:       15 * time.Minute,
+			VerifyTokenTTL:     48 * time.Hour,
+			ResetTokenTTL:      time.Hour,
+			TOTPIssuer:         "pastebin",
+		},
+		Limits: LimitsConfig{
+			Requests:       300,
+			Window:         time.Minute,
+			MaxBinsByUser:  10000,
+			MaxBytesByUser: 1 << 30,
+		},
+		Mail: MailConfig{
+			Driver:    "log",
+			From:      "pastebin@localhost",
+			PublicURL: "http://localhost:8080",
+			Dir:       "./mail",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+	}
+}
+
+// minJWTKeyLength is the shortest signing key accepted, 256 bits.
+const minJWTKeyLength = 32
+
+// Validate checks the settings are usable, reporting every problem.
+func (c *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, msg string) {
+		if !ok {
+			problems = append(problems, msg)
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr must be set")
+	for _, origin := range c.Server.CORSOrigins {
+		u, err := url.Parse(origin)
+		check(origin == "*" || (err == nil && u.Scheme != "" && u.Host != ""), "server.cors_origins: invalid origin "+origin)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		check(prefixErr == nil || addrErr == nil, "server.trusted_proxies: invalid address or CIDR range "+proxy)
+	}
+	check(c.Server.ReadHeaderTimeout >= 0 && c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 &&
+		c.Server.IdleTimeout >= 0 && c.Server.DrainDelay >= 0 && c.Server.ShutdownTimeout >= 0,
+		"server timeouts must not be negative")
+	check(c.Redis.Addr != "", "redis.addr must be set")
+	check(c.Storage.Dir != "", "storage.dir must be set")
+	check(c.Bins.TTL > 0, "bins.ttl must be positive")
+	check(c.Uploads.MaxSize > 0, "uploads.max_size must be positive")
+	check(c.Uploads.Expiry > 0, "uploads.expiry must be positive")
+	check(c.Uploads.JanitorInterval > 0, "uploads.janitor_interval must be positive")
+	check(c.Search.Index == "redis" || c.Search.Index == "memory", "search.index must be redis or memory")
+	check(c.Auth.JWTKey == "" || len(c.Auth.JWTKey) >= minJWTKeyLength, "auth.jwt_key must be at least 32 bytes long")
+	check(c.Bins.VisitorKey == "" || len(c.Bins.VisitorKey) >= minJWTKeyLength, "bins.visitor_key must be at least 32 bytes long")
+	check(c.Auth.TOTPKey == "" || len(c.Auth.TOTPKey) >= minJWTKeyLength, "auth.totp_key must be at least 32 bytes long")
+	check(c.Auth.TOTPIssuer != "" && !strings.Contains(c.Auth.TOTPIssuer, ":"), "auth.totp_issuer must be set and hold no colon")
+	check(c.Auth.AccessTokenTTL > 0, "auth.access_token_ttl must be positive")
+	check(c.Auth.PasswordMinLength > 0 && c.Auth.PasswordMinLength <= 72, "auth.password_min_length must be between 1 and 72")
+	check(c.Auth.PasswordMinClasses >= 0 && c.Auth.PasswordMinClasses <= 4, "auth.password_min_classes must be between 0 and 4")
+	check(c.Auth.LoginFreeAttempts >= 0 && c.Auth.LoginFreeAttempts < c.Auth.LoginMaxAttempts &&
+		c.Auth.LoginFreeAttempts < c.Auth.LoginIPMaxAttempts,
+		"auth.login_free_attempts must be lower than auth.login_max_attempts and auth.login_ip_max_attempts")
+	check(c.Auth.LoginBaseDelay > 0 && c.Auth.LoginLockout >= c.Auth.LoginBaseDelay,
+		"auth.login_base_delay must be positive and auth.login_lockout not shorter")
+	check(c.Auth.SessionTTL >= c.Auth.AccessTokenTTL, "auth.session_ttl must not be shorter than auth.access_token_ttl")
+	check(c.Limits.Requests >= 0 && c.Limits.Window > 0, "limits.requests must not be negative and limits.window must be positive")
+	check(c.Limits.MaxBinsByUser >= 0 && c.Limits.MaxBytesByUser >= 0 && c.Limits.MaxBinSize >= 0,
+		"limits quotas must not be negative")
+	check(c.Auth.VerifyTokenTTL > 0 && c.Auth.ResetTokenTTL > 0, "auth.verify_token_ttl and auth.reset_token_ttl must be positive")
+	check(c.Mail.Driver == "smtp" || c.Mail.Driver == "file" || c.Mail.Driver == "log", "mail.driver must be smtp, file or log")
+	check(c.Mail.From != "", "mail.from must be set")
+	u, err := url.Parse(c.Mail.PublicURL)
+	check(err == nil && u.Scheme != "" && u.Host != "", "mail.public_url must be an absolute URL")
+	check(c.Mail.Driver != "smtp" || c.Mail.SMTPAddr != "", "mail.smtp_addr must be set for the smtp driver")
+	check(c.Mail.Driver != "file" || c.Mail.Dir != "", "mail.dir must be set for the file driver")
+	check(c.Log.Level == "debug" || c.Log.Level == "info" || c.Log.Level == "warn" || c.Log.Level == "error",
+		"log.level must be debug, info, warn or error")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if len(problems) != 0 {
+		return errors.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the secrets in printed configurations.
+const redacted = "[redacted]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one leaf field of Config with its tags.
+type setting struct {
+	name   string
+	flag   string
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the leaf fields of c, addressable so they can be set.
+func settings(c *Config) []setting {
+	return walk(reflect.ValueOf(c).Elem(), "")
+}
+
+func walk(v reflect.Value, prefix string) []setting {
+	list := []setting{}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + field.Tag.Get("yaml")
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			list = append(list, walk(v.Field(i), name+".")...)
+			continue
+		}
+
+		list = append(list, setting{
+			name:   name,
+			flag:   field.Tag.Get("flag"),
+			env:    field.Tag.Get("env"),
+			help:   field.Tag.Get("help"),
+			secret: field.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+
+	return list
+}
+
+// set parses s into the field of the setting.
+func (s setting) set(raw string) error {
+	v := s.value
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.Errorf("%s: invalid duration %q", s.name, raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.Errorf("%s: invalid boolean %q", s.name, raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return errors.Errorf("%s: invalid integer %q", s.name, raw)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return errors.Errorf("%s: invalid integer %q", s.name, raw)
+		}
+		v.SetUint(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return errors.Errorf("%s: invalid number %q", s.name, raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.Errorf("%s: unsupported setting type %s", s.name, v.Type())
+	}
+
+	return nil
+}
+
+// String formats the field of the setting the way set parses it.
+func (s setting) String() string {
+	v := s.value
+
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+
+	return formatScalar(v.Interface())
+}
+
+func formatScalar(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	return ""
+}
+
+// flagValue records a flag until the lower precedence sources are
+// loaded.
+type flagValue struct {
+	setting setting
+	def     string
+	raw     *string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.def
+}
+
+func (f *flagValue) Set(raw string) error {
+	*f.raw = raw
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting.value.Kind() == reflect.Bool
+}
+
+// Loader reads the configuration from its sources.
+type Loader struct {
+	flags   *flag.FlagSet
+	file    *string
+	pending map[string]*string
+	byFlag  map[string]setting
+	cfg     Config
+}
+
+// NewLoader registers a flag for every setting on fs, and a -config flag
+// naming the configuration file.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{
+		flags:   fs,
+		pending: map[string]*string{},
+		byFlag:  map[string]setting{},
+		cfg:     Default(),
+	}
+
+	l.file = fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML or TOML configuration file (env "+EnvPrefix+"CONFIG)")
+
+	for _, s := range settings(&l.cfg) {
+		raw := new(string)
+		l.pending[s.flag] = raw
+		l.byFlag[s.flag] = s
+
+		def := s.String()
+		if s.secret {
+			def = ""
+		}
+
+		fs.Var(&flagValue{setting: s, def: def, raw: raw}, s.flag, s.help+" (env "+EnvPrefix+s.env+")")
+	}
+
+	return l
+}
+
+// Load applies the configuration file, the environment and the flags
+// set on the command line, in that order, and validates the result. The
+// flag set must be parsed first.
+func (l *Loader) Load() (*Config, error) {
+	if *l.file != "" {
+		err := loadFile(*l.file, &l.cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings(&l.cfg) {
+		raw, ok := os.LookupEnv(EnvPrefix + s.env)
+		if !ok {
+			continue
+		}
+
+		err := s.set(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "in %s%s", EnvPrefix, s.env)
+		}
+	}
+
+	var flagErr error
+	l.flags.Visit(func(f *flag.Flag) {
+		raw, ok := l.pending[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+
+		flagErr = l.byFlag[f.Name].set(*raw)
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	err := l.cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &l.cfg, nil
+}
+
+// loadFile overlays the file at path on cfg. Unknown keys are refused,
+// they are most likely typos.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt read configuration file %s", path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "couldnt parse configuration file %s", path)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt parse configuration file %s", path)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) != 0 {
+			return errors.Errorf("unknown setting %s in configuration file %s", undecoded[0], path)
+		}
+	default:
+		return errors.Errorf("configuration file %s must be .yaml, .yml or .toml", path)
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of c with its secrets hidden, to be printed.
+func (c Config) Redacted() Config {
+	for _, s := range settings(&c) {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString(redacted)
+		}
+	}
+
+	return c
+}
+
+// YAML renders c as a YAML configuration file.
+func (c Config) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt encode configuration")
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,252 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/store"
+)
+
+// adminUserRequest changes the role or the status of a user, fields left
+// out are kept.
+type adminUserRequest struct {
+	Role          *string `json:"role"`
+	Disabled      *bool   `json:"disabled"`
+	EmailVerified *bool   `json:"email_verified"`
+	// TOTPEnabled can only be set to false, removing the second factor
+	// of a user who lost it.
+	TOTPEnabled *bool `json:"totp_enabled"`
+}
+
+// listUsers lists every user.
+func listUsers(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := svc.GetAllUsers(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing users", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[users] something went wrong")
+			return
+		}
+
+		views := make([]store.PublicUser, 0, len(users))
+		for i := range users {
+			views = append(views, users[i].Public())
+		}
+
+		writeJSON(w, http.StatusOK, views)
+	}
+}
+
+// getUser returns the user with the email in the path.
+func getUser(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := svc.GetUserByEmail(r.Context(), strings.ToLower(chi.URLParam(r, "email")))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[user] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, user.Public())
+	}
+}
+
+// updateUser changes the role of a user, disables their account, marks
+// their email verified or removes their second factor.
+// Disabling an account ends its sessions. Admins cannot change their own
+// account, so there is always one admin left.
+func updateUser(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := strings.ToLower(chi.URLParam(r, "email"))
+		if email == currentUser(r.Context()) {
+			writeJSONError(w, http.StatusBadRequest, "cannot change your own account")
+			return
+		}
+
+		req := &adminUserRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		if req.TOTPEnabled != nil && *req.TOTPEnabled {
+			writeJSONError(w, http.StatusBadRequest, "users enable two-factor authentication themselves")
+			return
+		}
+
+		if req.Role != nil && !validRole(*req.Role) {
+			writeJSONError(w, http.StatusBadRequest, "role must be user, moderator or admin")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[user] something went wrong")
+			return
+		}
+
+		if req.Role != nil {
+			user.Role = *req.Role
+		}
+		if req.Disabled != nil {
+			user.Disabled = *req.Disabled
+		}
+		if req.EmailVerified != nil {
+			user.EmailVerified = *req.EmailVerified
+		}
+		if req.TOTPEnabled != nil {
+			err = resetTwoFactor(r.Context(), svc, user)
+		}
+
+		var updated *store.User
+		if err == nil {
+			updated, err = svc.UpdateUser(r.Context(), *user)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "updating user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[update user] something went wrong")
+			return
+		}
+
+		if updated.Disabled {
+			err = svc.DeleteSessionsByOwner(r.Context(), updated.Email)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "revoking sessions", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[update user] something went wrong")
+				return
+			}
+		}
+
+		slog.InfoContext(r.Context(), "user updated", "user", updated.Email, "role", updated.Role, "disabled", updated.Disabled)
+		writeJSON(w, http.StatusOK, updated.Public())
+	}
+}
+
+// deleteUser deletes a user along with their sessions and API tokens.
+// Their bins are kept.
+func deleteUser(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := strings.ToLower(chi.URLParam(r, "email"))
+		if email == currentUser(r.Context()) {
+			writeJSONError(w, http.StatusBadRequest, "cannot delete your own account")
+			return
+		}
+
+		err := svc.DeleteUser(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[user] something went wrong")
+			return
+		}
+
+		err = revokeCredentials(r.Context(), svc, email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "revoking credentials", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[revoke user] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "user deleted", "user", email)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// revokeCredentials ends the sessions and deletes the API tokens of email.
+func revokeCredentials(ctx context.Context, svc store.Store, email string) error {
+	err := svc.DeleteSessionsByOwner(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := svc.GetAPITokensByOwner(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err = svc.DeleteAPIToken(ctx, email, token.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteAnyBin deletes a bin and its files whoever owns it.
+func deleteAnyBin(svc store.Store, blobs blob.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bin, err := deleteBin(r.Context(), svc, blobs, chi.URLParam(r, "binID"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "deleting bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[delete bin] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "bin deleted by moderation", "bin", bin.ID, "owner", bin.Owner)
+		writeJSON(w, http.StatusOK, bin.Public())
+	}
+}
+
+// promoteAdmins gives the admin role to the existing users among emails.
+func promoteAdmins(ctx context.Context, svc store.Store, emails []string) error {
+	for _, email := range emails {
+		user, err := svc.GetUserByEmail(ctx, strings.ToLower(email))
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if user.Role == store.RoleAdmin {
+			continue
+		}
+
+		user.Role = store.RoleAdmin
+		_, err = svc.UpdateUser(ctx, *user)
+		if err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "user promoted to admin", "user", email)
+	}
+
+	return nil
+}
+
+// isAdminEmail reports whether email is configured as an admin.
+func isAdminEmail(opts Options, email string) bool {
+	for _, admin := range opts.Admins {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+
+	return false
+}
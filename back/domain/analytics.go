@@ -0,0 +1,195 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// maxStatsPoints bounds the number of points of a stats request, a
+// month of hours.
+const maxStatsPoints = 31 * 24
+
+// How many of the most viewed bins the global statistics list.
+const (
+	defaultStatsTop = 10
+	maxStatsTop     = 100
+)
+
+// viewRecorder records the views of bins. Client addresses are hashed
+// with a secret key so they cannot be read back from the store.
+type viewRecorder struct {
+	svc    store.Store
+	secret []byte
+}
+
+func newViewRecorder(svc store.Store, secret []byte) *viewRecorder {
+	return &viewRecorder{svc: svc, secret: secret}
+}
+
+// record saves a view of bin and counts it as a click, once the caller
+// was allowed to read it. Failures are logged, they never fail the
+// request.
+func (v *viewRecorder) record(r *http.Request, bin *store.Bin) {
+	err := v.svc.CountClic(r.Context(), bin)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "counting click", "bin", bin.ID, "err", err)
+	}
+
+	err = v.svc.RecordView(r.Context(), store.ViewEvent{
+		BinID:     bin.ID,
+		Time:      time.Now(),
+		Referrer:  referrerHost(r.Referer()),
+		UserAgent: userAgentFamily(r.UserAgent()),
+		Visitor:   v.visitorHash(r),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "recording view", "bin", bin.ID, "err", err)
+	}
+}
+
+func (v *viewRecorder) visitorHash(r *http.Request) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(clientIP(r)))
+
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// referrerHost keeps only the host of a referrer URL.
+func referrerHost(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// userAgentFamily reduces a User-Agent header to a browser or client
+// family. The order matters, most browsers also claim to be Safari or
+// Mozilla.
+func userAgentFamily(ua string) string {
+	lower := strings.ToLower(ua)
+
+	switch {
+	case lower == "":
+		return "unknown"
+	case strings.Contains(lower, "bot") || strings.Contains(lower, "crawler") || strings.Contains(lower, "spider"):
+		return "bot"
+	case strings.Contains(lower, "curl/"):
+		return "curl"
+	case strings.Contains(lower, "wget/"):
+		return "wget"
+	case strings.Contains(lower, "edg/"):
+		return "edge"
+	case strings.Contains(lower, "opr/") || strings.Contains(lower, "opera"):
+		return "opera"
+	case strings.Contains(lower, "firefox/"):
+		return "firefox"
+	case strings.Contains(lower, "chrome/") || strings.Contains(lower, "crios/"):
+		return "chrome"
+	case strings.Contains(lower, "safari/"):
+		return "safari"
+	}
+
+	return "other"
+}
+
+// parseStatsTime reads a RFC 3339 timestamp or a plain date.
+func parseStatsTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+
+	return t, nil
+}
+
+// getBinStats returns the view time series of a bin. The range defaults
+// to the last day by hour, or the last 30 days by day.
+func getBinStats(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		granularity := query.Get("granularity")
+		if granularity == "" {
+			granularity = store.GranularityHour
+		}
+		if granularity != store.GranularityHour && granularity != store.GranularityDay {
+			writeJSONError(w, http.StatusBadRequest, "granularity must be hour or day")
+			return
+		}
+
+		to := time.Now()
+		if query.Get("to") != "" {
+			t, err := parseStatsTime(query.Get("to"))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			to = t
+		}
+
+		step := time.Hour
+		if granularity == store.GranularityDay {
+			step = 24 * time.Hour
+		}
+
+		from := to.Add(-24 * time.Hour)
+		if granularity == store.GranularityDay {
+			from = to.AddDate(0, 0, -30)
+		}
+		if query.Get("from") != "" {
+			t, err := parseStatsTime(query.Get("from"))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			from = t
+		}
+
+		if !from.Before(to) {
+			writeJSONError(w, http.StatusBadRequest, "from must be before to")
+			return
+		}
+		if to.Sub(from)/step > maxStatsPoints {
+			writeJSONError(w, http.StatusBadRequest, "time range too long for this granularity")
+			return
+		}
+
+		bin, err := svc.PeekBinByAlias(r.Context(), chi.URLParam(r, "alias"))
+		if err != nil || !canView(r.Context(), bin) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+
+		if !canEdit(r, bin) {
+			writeJSONError(w, http.StatusForbidden, "only the owner can see the statistics of this bin")
+			return
+		}
+
+		series, err := svc.GetViewSeries(r.Context(), bin.ID, from, to, granularity)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading view series", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[analytics] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, series)
+	}
+}
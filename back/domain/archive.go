@@ -0,0 +1,296 @@
+package domain
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/store"
+	"pastebin/thumbnail"
+)
+
+// isTextBin reports whether bin holds text in Contain rather than files.
+func isTextBin(bin *store.Bin) bool {
+	return len(bin.Files) == 0 && bin.Filename == ""
+}
+
+// binFiles lists the files of a bin, including bins created before they
+// could hold more than one file. Text bins have none, their Contain is
+// not a blob key.
+func binFiles(bin *store.Bin) []store.BinFile {
+	if len(bin.Files) != 0 {
+		return bin.Files
+	}
+
+	if isTextBin(bin) {
+		return nil
+	}
+
+	name := bin.Filename
+	if name == "" {
+		name = filepath.Base(bin.Contain)
+	}
+
+	return []store.BinFile{{Name: name, Key: bin.Contain}}
+}
+
+// getBinFile serves one named file of a bin.
+func getBinFile(svc store.Store, blobs blob.Storage, views *viewRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bin, err := svc.PeekBinByAlias(r.Context(), chi.URLParam(r, "alias"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[get bin] something went wrong")
+			return
+		}
+
+		if !canView(r.Context(), bin) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+
+		if isLocked(r, bin) {
+			writeJSONError(w, http.StatusUnauthorized, "password required")
+			return
+		}
+
+		if isTextBin(bin) {
+			writeJSONError(w, http.StatusNotFound, "bin has no files")
+			return
+		}
+
+		name := chi.URLParam(r, "name")
+		for _, file := range binFiles(bin) {
+			if file.Name == name {
+				if !claimBin(r.Context(), svc, bin) {
+					writeJSONError(w, http.StatusNotFound, "bin not found")
+					return
+				}
+
+				views.record(r, bin)
+				serveFile(w, r, blobs, file)
+				burnFiles(r.Context(), blobs, bin)
+				return
+			}
+		}
+
+		writeJSONError(w, http.StatusNotFound, "file not found in bin")
+	}
+}
+
+// getBinArchive streams every file of a bin as a zip or tar.gz archive,
+// built on the fly. Only the stripped images of a tar are staged on disk.
+func getBinArchive(svc store.Store, blobs blob.Storage, views *viewRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "zip"
+		}
+
+		var write func(context.Context, io.Writer, blob.Storage, []store.BinFile) error
+		var contentType, ext string
+		switch format {
+		case "zip":
+			write, contentType, ext = writeZip, "application/zip", ".zip"
+		case "tar.gz":
+			write, contentType, ext = writeTarGz, "application/gzip", ".tar.gz"
+		default:
+			writeJSONError(w, http.StatusBadRequest, "format must be zip or tar.gz")
+			return
+		}
+
+		bin, err := svc.PeekBinByAlias(r.Context(), chi.URLParam(r, "alias"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[archive] something went wrong")
+			return
+		}
+
+		if !canView(r.Context(), bin) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+
+		if isLocked(r, bin) {
+			writeJSONError(w, http.StatusUnauthorized, "password required")
+			return
+		}
+
+		if isTextBin(bin) {
+			writeJSONError(w, http.StatusNotFound, "bin has no files")
+			return
+		}
+
+		if !claimBin(r.Context(), svc, bin) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+
+		views.record(r, bin)
+
+		name := bin.Alias
+		if name == "" {
+			name = bin.ID
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", attachment(name+ext))
+
+		// the status is already sent, a failure can only cut the archive short
+		err = write(r.Context(), w, blobs, binFiles(bin))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "streaming archive", "bin", bin.ID, "err", err)
+		}
+		burnFiles(r.Context(), blobs, bin)
+	}
+}
+
+// openArchived opens a file to add to an archive. It tells whether the
+// file is a JPEG or PNG image, to strip of its metadata like serveFile
+// does.
+func openArchived(ctx context.Context, blobs blob.Storage, file store.BinFile) (blob.Object, bool, error) {
+	f, err := blobs.Open(ctx, file.Key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	contentType, err := sniffContentType(f)
+	if err != nil {
+		f.Close()
+		return nil, false, errors.Wrapf(err, "couldnt read %s", file.Name)
+	}
+
+	return f, hasMetadata(contentType), nil
+}
+
+func writeZip(ctx context.Context, w io.Writer, blobs blob.Storage, files []store.BinFile) error {
+	zw := zip.NewWriter(w)
+
+	for _, file := range files {
+		f, strip, err := openArchived(ctx, blobs, file)
+		if err != nil {
+			return err
+		}
+
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: f.ModTime(),
+		})
+		if err == nil && strip {
+			err = thumbnail.StripMetadata(entry, f)
+		} else if err == nil {
+			_, err = io.Copy(entry, f)
+		}
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "couldnt add %s to zip", file.Name)
+		}
+	}
+
+	return zw.Close()
+}
+
+// stageStripped strips the metadata of an image into a temporary file,
+// as tar needs the size of an entry up front. The caller removes it.
+func stageStripped(r io.Reader) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "pastebin-archive-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = thumbnail.StripMetadata(tmp, r)
+	if err != nil {
+		removeStaged(tmp)
+		return nil, 0, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeStaged(tmp)
+		return nil, 0, err
+	}
+
+	return tmp, size, nil
+}
+
+func removeStaged(tmp *os.File) {
+	tmp.Close()
+	os.Remove(tmp.Name())
+}
+
+// addToTar writes one file of a bin as a tar entry.
+func addToTar(ctx context.Context, tw *tar.Writer, blobs blob.Storage, file store.BinFile) error {
+	f, strip, err := openArchived(ctx, blobs, file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var content io.Reader = f
+	size := f.Size()
+	if strip {
+		tmp, n, err := stageStripped(f)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt strip %s", file.Name)
+		}
+		defer removeStaged(tmp)
+
+		content, size = tmp, n
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    file.Name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: f.ModTime(),
+	})
+	if err == nil {
+		_, err = io.Copy(tw, content)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "couldnt add %s to tar", file.Name)
+	}
+
+	return nil
+}
+
+func writeTarGz(ctx context.Context, w io.Writer, blobs blob.Storage, files []store.BinFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, file := range files {
+		err := addToTar(ctx, tw, blobs, file)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
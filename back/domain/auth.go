@@ -0,0 +1,292 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"pastebin/store"
+	"pastebin/tracing"
+)
+
+type contextKey int
+
+const (
+	userEmailKey contextKey = iota
+	tokenScopesKey
+	sessionIDKey
+	userRoleKey
+	tokenIDKey
+)
+
+// authenticate reads the bearer token of the caller, if any: an access
+// token of a session opened by /users/login or an API token, and records
+// the email of the caller in the request context. Requests without a token go through
+// anonymously, an invalid token is refused.
+func authenticate(secretKey []byte, svc store.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tokenString, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				writeJSONError(w, http.StatusUnauthorized, "invalid authorization header")
+				return
+			}
+
+			if strings.HasPrefix(tokenString, apiTokenPrefix) {
+				token, err := svc.GetAPIToken(r.Context(), tokenString)
+				if errors.Is(err, store.ErrNotFound) {
+					writeJSONError(w, http.StatusUnauthorized, "invalid token")
+					return
+				}
+				if err != nil {
+					slog.ErrorContext(r.Context(), "checking api token", "err", err)
+					writeJSONError(w, http.StatusInternalServerError, "[auth] something went wrong")
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), tokenScopesKey, token.Scopes)
+				ctx = context.WithValue(ctx, tokenIDKey, token.ID)
+				serveAs(w, r.WithContext(ctx), next, svc, token.Owner)
+				return
+			}
+
+			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, jwt.ErrSignatureInvalid
+				}
+				return secretKey, nil
+			})
+			if err != nil || !token.Valid {
+				writeJSONError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+
+			claims, _ := token.Claims.(jwt.MapClaims)
+			email, _ := claims["email"].(string)
+			sid, _ := claims["sid"].(string)
+			if email == "" || sid == "" {
+				writeJSONError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+
+			// access tokens die with their session
+			session, err := svc.GetSession(r.Context(), sid)
+			if errors.Is(err, store.ErrNotFound) || (err == nil && session.Owner != email) {
+				writeJSONError(w, http.StatusUnauthorized, "session revoked")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "checking session", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[auth] something went wrong")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), sessionIDKey, sid)
+			serveAs(w, r.WithContext(ctx), next, svc, email)
+		})
+	}
+}
+
+// serveAs serves r on behalf of the user with the given email, recording
+// their email and role in the request context. Deleted and disabled
+// users are refused.
+func serveAs(w http.ResponseWriter, r *http.Request, next http.Handler, svc store.Store, email string) {
+	user, err := svc.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading user", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[auth] something went wrong")
+		return
+	}
+
+	if user.Disabled {
+		writeJSONError(w, http.StatusForbidden, "account disabled")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userEmailKey, user.Email)
+	ctx = context.WithValue(ctx, userRoleKey, user.Role)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// apiTokenPrefix starts the secrets of API tokens, JWTs never do.
+const apiTokenPrefix = "pb_"
+
+// hasScope reports whether the caller may act with scope. Logged in users
+// have every scope, API tokens the ones they were given. bins:write
+// implies bins:read and admin implies everything.
+func hasScope(ctx context.Context, scope string) bool {
+	scopes, isToken := ctx.Value(tokenScopesKey).([]string)
+	if !isToken {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == scope || s == store.ScopeAdmin || (s == store.ScopeBinsWrite && scope == store.ScopeBinsRead) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireScope refuses API tokens without scope.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasScope(r.Context(), scope) {
+				writeJSONError(w, http.StatusForbidden, "token lacks the "+scope+" scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireMethodScope refuses API tokens without bins:read for reading
+// requests, or without bins:write for the others.
+func requireMethodScope(next http.Handler) http.Handler {
+	read, write := requireScope(store.ScopeBinsRead)(next), requireScope(store.ScopeBinsWrite)(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			read.ServeHTTP(w, r)
+		default:
+			write.ServeHTTP(w, r)
+		}
+	})
+}
+
+// currentUser returns the email of the authenticated caller, or "".
+func currentUser(ctx context.Context) string {
+	email, _ := ctx.Value(userEmailKey).(string)
+	return email
+}
+
+// currentSession returns the login session of the caller, or "" for
+// anonymous callers and API tokens.
+func currentSession(ctx context.Context) string {
+	id, _ := ctx.Value(sessionIDKey).(string)
+	return id
+}
+
+// canView reports whether the caller may read bin.
+func canView(ctx context.Context, bin *store.Bin) bool {
+	if bin.Visibility != store.VisibilityPrivate {
+		return true
+	}
+
+	return bin.Owner != "" && bin.Owner == currentUser(ctx)
+}
+
+// isListed reports whether bin shows up for the caller in listings and
+// search results. Protected bins are only listed to their owner, burn
+// after read bins to nobody as listing them would read them.
+func isListed(ctx context.Context, bin *store.Bin) bool {
+	if bin.BurnAfterRead {
+		return false
+	}
+
+	if (bin.Visibility == "" || bin.Visibility == store.VisibilityPublic) && !bin.Protected {
+		return true
+	}
+
+	return bin.Owner != "" && bin.Owner == currentUser(ctx)
+}
+
+// binPasswordHeader carries the password of a protected bin.
+const binPasswordHeader = "X-Bin-Password"
+
+// isLocked reports whether bin is protected by a password the caller did
+// not give. Owners need no password.
+func isLocked(r *http.Request, bin *store.Bin) bool {
+	if !bin.Protected {
+		return false
+	}
+
+	if bin.Owner != "" && bin.Owner == currentUser(r.Context()) {
+		return false
+	}
+
+	return !checkPassword(r.Context(), bin.PasswordHash, r.Header.Get(binPasswordHeader))
+}
+
+// validVisibility reports whether v is an accepted visibility value.
+func validVisibility(v string) bool {
+	switch v {
+	case "", store.VisibilityPublic, store.VisibilityUnlisted, store.VisibilityPrivate:
+		return true
+	}
+
+	return false
+}
+
+// binEditTokenHeader carries the edit token of a bin without an owner.
+const binEditTokenHeader = "X-Edit-Token"
+
+// canEdit reports whether the caller may change bin. Bins created
+// anonymously can only be changed with the edit token given to their
+// creator.
+func canEdit(r *http.Request, bin *store.Bin) bool {
+	if bin.Owner == "" {
+		return bin.CheckEditToken(r.Header.Get(binEditTokenHeader))
+	}
+
+	return bin.Owner == currentUser(r.Context())
+}
+
+// requireUser refuses anonymous requests.
+func requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r.Context()) == "" {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireSession refuses callers without a login session, so that API
+// tokens cannot manage the account they act for, such as minting
+// tokens outliving their own.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r.Context()) == "" {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		if currentSession(r.Context()) == "" {
+			writeJSONError(w, http.StatusForbidden, "a login session is required, not an API token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkPassword compares password with a bcrypt hash, in its own span as
+// it is slow on purpose.
+func checkPassword(ctx context.Context, hash, password string) bool {
+	_, span := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
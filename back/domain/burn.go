@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+
+	"pastebin/blob"
+	"pastebin/store"
+)
+
+// claimBin deletes a burn after read bin before its content is served,
+// so that only one reader gets it. It reports false when another reader
+// claimed it first.
+func claimBin(ctx context.Context, svc store.Store, bin *store.Bin) bool {
+	if !bin.BurnAfterRead {
+		return true
+	}
+
+	_, err := svc.DeleteBinByID(ctx, bin.ID)
+	if err != nil {
+		slog.InfoContext(ctx, "burn after read bin already read", "bin", bin.ID, "err", err)
+		return false
+	}
+
+	return true
+}
+
+// burnFiles deletes the files of a claimed burn after read bin once they
+// are served.
+func burnFiles(ctx context.Context, blobs blob.Storage, bin *store.Bin) {
+	if bin.BurnAfterRead && !isTextBin(bin) {
+		deleteFiles(ctx, blobs, binFiles(bin))
+	}
+}
@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"context"
+
+	"pastebin/store"
+)
+
+// Bins is the part of a Store the command line functions use. Both a
+// Store and a client of a remote server provide it.
+type Bins interface {
+	CreateBin(ctx context.Context, bin store.Bin) (*store.Bin, error)
+	GetAllBins(ctx context.Context) ([]store.Bin, error)
+	GetBinByAlias(ctx context.Context, alias string) (*store.Bin, error)
+	UpdateBin(ctx context.Context, id string, patch store.BinPatch) (*store.Bin, error)
+	DeleteBinByID(ctx context.Context, id string) (*store.Bin, error)
+	GetStats(ctx context.Context, top int) (*store.Statistics, error)
+}
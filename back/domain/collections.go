@@ -0,0 +1,229 @@
+package domain
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+const maxCollectionNameLength = 100
+
+type collectionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type collectionBinsRequest struct {
+	BinIDs []string `json:"bin_ids"`
+}
+
+// collectionWithBins is a collection along with the bins the caller can
+// see in it.
+type collectionWithBins struct {
+	*store.Collection
+	Bins []store.PublicBin `json:"bins"`
+}
+
+func decodeCollectionRequest(w http.ResponseWriter, r *http.Request) (*collectionRequest, bool) {
+	req := &collectionRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxCollectionNameLength {
+		writeJSONError(w, http.StatusBadRequest, "name is required and limited to 100 characters")
+		return nil, false
+	}
+
+	return req, true
+}
+
+// loadCollection fetches the collection of the collectionID URL
+// parameter. Collections of other users are reported as missing.
+func loadCollection(w http.ResponseWriter, r *http.Request, svc store.Store) (*store.Collection, bool) {
+	collection, err := svc.GetCollection(r.Context(), chi.URLParam(r, "collectionID"))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && collection.Owner != currentUser(r.Context())) {
+		writeJSONError(w, http.StatusNotFound, "collection not found")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading collection", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[collection] something went wrong")
+		return nil, false
+	}
+
+	return collection, true
+}
+
+func createCollection(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeCollectionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		collection, err := svc.CreateCollection(r.Context(), store.Collection{
+			Name:        req.Name,
+			Description: req.Description,
+			Owner:       currentUser(r.Context()),
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "creating collection", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[create collection] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, collection)
+	}
+}
+
+func getCollections(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collections, err := svc.GetCollectionsByOwner(r.Context(), currentUser(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing collections", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[collections] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, collections)
+	}
+}
+
+func getCollection(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, svc)
+		if !ok {
+			return
+		}
+
+		bins := []store.PublicBin{}
+		for _, id := range collection.BinIDs {
+			bin, err := svc.GetBinByID(r.Context(), id)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "loading bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[collection] something went wrong")
+				return
+			}
+
+			// the password of protected bins is not given here, and burn
+			// after read bins are only read through their alias
+			if canView(r.Context(), bin) && !bin.BurnAfterRead && (!bin.Protected || bin.Owner == currentUser(r.Context())) {
+				bins = append(bins, bin.Public())
+			}
+		}
+
+		writeJSON(w, http.StatusOK, collectionWithBins{Collection: collection, Bins: bins})
+	}
+}
+
+func updateCollection(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, svc)
+		if !ok {
+			return
+		}
+
+		req, ok := decodeCollectionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		collection.Name = req.Name
+		collection.Description = req.Description
+		collection, err := svc.UpdateCollection(r.Context(), *collection)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "updating collection", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[update collection] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, collection)
+	}
+}
+
+func deleteCollection(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, svc)
+		if !ok {
+			return
+		}
+
+		err := svc.DeleteCollection(r.Context(), collection.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "deleting collection", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[delete collection] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, collection)
+	}
+}
+
+func addCollectionBins(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, svc)
+		if !ok {
+			return
+		}
+
+		req := collectionBinsRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		for _, id := range req.BinIDs {
+			bin, err := svc.GetBinByID(r.Context(), id)
+			if errors.Is(err, store.ErrNotFound) || (err == nil && !canView(r.Context(), bin)) {
+				writeJSONError(w, http.StatusBadRequest, "bin "+id+" not found")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "loading bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[update collection] something went wrong")
+				return
+			}
+		}
+
+		collection, err = svc.AddCollectionBins(r.Context(), collection.ID, req.BinIDs)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "adding bins to collection", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[update collection] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, collection)
+	}
+}
+
+func removeCollectionBin(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, svc)
+		if !ok {
+			return
+		}
+
+		collection, err := svc.RemoveCollectionBins(r.Context(), collection.ID, []string{chi.URLParam(r, "binID")})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "removing bin from collection", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[update collection] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, collection)
+	}
+}
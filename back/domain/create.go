@@ -6,11 +6,13 @@ import (
 	"pastebin/store"
 )
 
-func CreateBin(svc store.Store) func(context.Context, string, string) error {
+func CreateBin(svc Bins) func(context.Context, string, string) error {
 	return func(ctx context.Context, contain string, alias string) error {
 		bin, err := svc.CreateBin(ctx, store.Bin{
-			Alias: alias,
-			Contain: contain,
+			Alias:       alias,
+			Contain:     contain,
+			ContentType: "text/plain",
+			Size:        int64(len(contain)),
 		})
 		if err != nil {
 			return err
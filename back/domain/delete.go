@@ -4,11 +4,9 @@ import (
 	"context"
 
 	"github.com/pkg/errors"
-
-	"pastebin/store"
 )
 
-func DeleteBinByID(svc store.Store) func(context.Context, string) error {
+func DeleteBinByID(svc Bins) func(context.Context, string) error {
 	return func(ctx context.Context, binID string) error {
 		bin, err := svc.DeleteBinByID(ctx, binID)
 		if err != nil {
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 
 	"pastebin/store"
 )
 
-func ListBins(svc store.Store) func(context.Context) error {
+func ListBins(svc Bins) func(context.Context) error {
 	return func(ctx context.Context) error {
 		bins, err := svc.GetAllBins(ctx)
 		if err != nil {
@@ -23,21 +24,18 @@ func ListBins(svc store.Store) func(context.Context) error {
 	}
 }
 
-// func GetStats(svc store.Store) func(context.Context) error {
-// 	return func(ctx context.Context) error {
-// 		bins, err := svc.GetStats(ctx)
-// 		if err != nil {
-// 			return errors.Wrap(err, "couldnt get all bins")
-// 		}
-
-		// à faire : coder un PrintStats(stats...)
-// 		// PrintStats(stats...)
+func GetStats(svc Bins) func(context.Context, int) error {
+	return func(ctx context.Context, top int) error {
+		stats, err := svc.GetStats(ctx, top)
+		if err != nil {
+			return errors.Wrap(err, "couldnt get statistics")
+		}
 
-// 		return nil
-// 	}
-// }
+		return printJSON(stats)
+	}
+}
 
-func GetBinByAlias(svc store.Store) func(context.Context, string) error {
+func GetBinByAlias(svc Bins) func(context.Context, string) error {
 	return func(ctx context.Context, binID string) error {
 		bin, err := svc.GetBinByAlias(ctx, binID)
 		if err != nil {
@@ -50,24 +48,28 @@ func GetBinByAlias(svc store.Store) func(context.Context, string) error {
 	}
 }
 
+// PrintBins writes bins to the standard output as indented JSON, one
+// document per bin.
 func PrintBins(bins ...store.Bin) {
-	numberOfBins := len(bins)
-
-	if numberOfBins == 0 {
-		fmt.Println("no bins to print")
+	if len(bins) == 0 {
+		fmt.Fprintln(os.Stderr, "no bins to print")
 		return
 	}
 
-	fmt.Printf("printing %d bins", numberOfBins)
 	for _, bin := range bins {
-		fmt.Println(jsonify(bin))
+		err := printJSON(bin.Public())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
 	}
 }
 
-func jsonify(data store.Bin) (string, error) {
-	val, err := json.MarshalIndent(data, "", "    ")
+func printJSON(v interface{}) error {
+	val, err := json.MarshalIndent(v, "", "    ")
 	if err != nil {
-		return "", err
+		return errors.Wrap(err, "couldnt json marshal")
 	}
-	return string(val), nil
+
+	fmt.Println(string(val))
+	return nil
 }
@@ -1,345 +1,720 @@
 package domain
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
-	"os"
-	"pastebin/store"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"pastebin/blob"
+	"pastebin/health"
+	"pastebin/logging"
+	"pastebin/mailer"
+	"pastebin/metrics"
+	"pastebin/search"
+	"pastebin/store"
+	"pastebin/tracing"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
 	"github.com/rs/cors"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func ServeAPI(svc store.Store, secretKey []byte) func() error {
-	return func() error {
+// Options holds the settings of the HTTP API.
+type Options struct {
+	SecretKey     []byte
+	VisitorKey    []byte
+	MaxUploadSize int64
+	UploadExpiry  time.Duration
+	Policy        UploadPolicy
+	Health        *health.Checker
+	Server        ServerOptions
+	// CORSOrigins are the origins allowed to call the API from a browser.
+	CORSOrigins []string
+	// TrustedProxies are the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header is trusted.
+	TrustedProxies []string
+	// AccessTokenTTL is the lifetime of the access tokens, SessionTTL the
+	// one of the refresh tokens renewing them.
+	AccessTokenTTL time.Duration
+	SessionTTL     time.Duration
+	// Admins are the emails of the users given the admin role.
+	Admins         []string
+	PasswordPolicy PasswordPolicy
+	// LoginProtection throttles failed logins, see its zero value
+	// defaults in ServeAPI.
+	LoginProtection LoginProtection
+	RateLimit       RateLimit
+	Quota           Quota
+	// Mailer sends the verification and password reset links, which
+	// point to the pages of the front end at PublicURL.
+	Mailer    mailer.Mailer
+	PublicURL string
+	// RequireVerifiedEmail refuses logins until the email is verified.
+	RequireVerifiedEmail bool
+	VerifyTokenTTL       time.Duration
+	ResetTokenTTL        time.Duration
+	// TOTPIssuer names the service in the authenticator apps.
+	TOTPIssuer string
+}
+
+// ServerOptions holds the settings of the HTTP server. Zero timeouts
+// mean no timeout.
+type ServerOptions struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// DrainDelay is how long the server keeps serving once it reports
+	// draining, so load balancers stop routing to it first.
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds the wait for in-flight requests.
+	ShutdownTimeout time.Duration
+}
+
+// ServeAPI returns a function serving the API until ctx is cancelled,
+// then draining the in-flight requests.
+func ServeAPI(svc store.Store, blobs blob.Storage, index search.Index, opts Options) func(ctx context.Context) error {
+	if opts.Health == nil {
+		opts.Health = health.New()
+	}
+
+	if opts.Server.Addr == "" {
+		opts.Server.Addr = ":4000"
+	}
+
+	if opts.CORSOrigins == nil {
+		opts.CORSOrigins = []string{"http://localhost:8080"}
+	}
+
+	if opts.AccessTokenTTL == 0 {
+		opts.AccessTokenTTL = defaultAccessTokenTTL
+	}
+
+	if opts.SessionTTL == 0 {
+		opts.SessionTTL = defaultSessionTTL
+	}
+
+	if opts.PasswordPolicy.MinLength == 0 {
+		opts.PasswordPolicy.MinLength = 8
+	}
+
+	if opts.Mailer == nil {
+		opts.Mailer = mailer.NewLogMailer()
+	}
+
+	if opts.PublicURL == "" {
+		opts.PublicURL = "http://localhost:8080"
+	}
+
+	if opts.VerifyTokenTTL == 0 {
+		opts.VerifyTokenTTL = defaultVerifyTokenTTL
+	}
+
+	if opts.ResetTokenTTL == 0 {
+		opts.ResetTokenTTL = defaultResetTokenTTL
+	}
+
+	if opts.TOTPIssuer == "" {
+		opts.TOTPIssuer = "pastebin"
+	}
+
+	if opts.LoginProtection == (LoginProtection{}) {
+		opts.LoginProtection = LoginProtection{
+			FreeAttempts:  3,
+			MaxAttempts:   10,
+			IPMaxAttempts: 100,
+			BaseDelay:     time.Second,
+			Lockout:       15 * time.Minute,
+		}
+	}
+
+	return func(ctx context.Context) error {
+		proxies, err := parseTrustedProxies(opts.TrustedProxies)
+		if err != nil {
+			return err
+		}
+
+		err = promoteAdmins(ctx, svc, opts.Admins)
+		if err != nil {
+			return errors.Wrap(err, "couldnt promote admins")
+		}
+
 		c := cors.New(cors.Options{
-			AllowedOrigins:   []string{"http://localhost:8080"}, // Autorise seulement ce domaine
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-			AllowedHeaders:   []string{"Content-Type", "Authorization"},
+			AllowedOrigins:   opts.CORSOrigins,
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "X-Request-ID", binPasswordHeader, binEditTokenHeader},
+			ExposedHeaders:   []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Expires", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 			AllowCredentials: true,
 		})
+		views := newViewRecorder(svc, opts.VisitorKey)
 
 		router := chi.NewRouter()
 		// getBinByAlias returns the bin with the correct Alias.
 		getBinByAlias := func(w http.ResponseWriter, r *http.Request) {
 			alias := chi.URLParam(r, "alias")
 
-			bin, err := svc.GetBinByAlias(r.Context(), alias)
+			bin, err := svc.PeekBinByAlias(r.Context(), alias)
+			if errors.Is(err, store.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "loading bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[get bin] something went wrong")
+
+				return
+			}
+
+			if !canView(r.Context(), bin) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
+
+			if isLocked(r, bin) {
+				writeJSONError(w, http.StatusUnauthorized, "password required")
+				return
+			}
 
+			// the content of file bins is only read through their files
+			if isTextBin(bin) && !claimBin(r.Context(), svc, bin) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
 				return
 			}
 
-			err = json.NewEncoder(w).Encode(bin)
+			views.record(r, bin)
+
+			err = json.NewEncoder(w).Encode(bin.Public())
 			if err != nil {
-				fmt.Fprintf(w, "%v", err.Error())
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
 			}
 		}
 
 		getFileByAlias := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
 			alias := chi.URLParam(r, "alias")
 
-			bin, err := svc.GetBinByAlias(r.Context(), alias)
+			bin, err := svc.PeekBinByAlias(r.Context(), alias)
+			if errors.Is(err, store.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "loading bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[get bin] something went wrong")
 				return
 			}
 
-			// open file (check if exists)
-			_, err = os.Stat(bin.Contain)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("file not exist ")
+			if !canView(r.Context(), bin) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
 				return
 			}
 
-			// force a download with the content- disposition field
-			w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(bin.Contain))
+			if isLocked(r, bin) {
+				writeJSONError(w, http.StatusUnauthorized, "password required")
+				return
+			}
 
-			// serve file out.
-			http.ServeFile(w, r, bin.Contain)
+			if !claimBin(r.Context(), svc, bin) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
+
+			views.record(r, bin)
+
+			// text bins are served raw, for curl and the command line
+			if isTextBin(bin) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				io.WriteString(w, bin.Contain)
+				return
+			}
+
+			serveFile(w, r, blobs, binFiles(bin)[0])
+			burnFiles(r.Context(), blobs, bin)
 		}
 
-		// updateBinByID update the bin with the given ID
-		// returns the updated bin.
+		// updateBinByID applies the JSON patch in the body to the bin
+		// with the given ID and returns the updated bin. Fields outside
+		// of store.BinPatch are ignored.
 		updateBinByID := func(w http.ResponseWriter, r *http.Request) {
-			binID := chi.URLParam(r, "binID")
-
-			bin := &store.Bin{}
-			err := json.NewDecoder(r.Body).Decode(bin)
+			patch := &store.BinPatch{}
+			err := json.NewDecoder(r.Body).Decode(patch)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				writeJSONError(w, http.StatusUnprocessableEntity, "Invalid request payload")
+				return
+			}
 
+			bin, ok := loadEditableBin(w, r, svc)
+			if !ok {
 				return
 			}
 
-			bin.ID = binID
-			bin, err = svc.UpdateBin(r.Context(), *bin)
+			err = checkPatch(opts.Policy, bin, patch)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeUploadFailure(w, err, err.Error())
+				return
+			}
+
+			if patch.Contain != nil {
+				err = checkResize(r.Context(), svc, opts.Quota, bin, int64(len(*patch.Contain)))
+				if err != nil {
+					writeQuotaError(w, r, err)
+					return
+				}
+			}
 
+			bin, err = svc.UpdateBin(r.Context(), bin.ID, *patch)
+			if errors.Is(err, store.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
+			if errors.Is(err, store.ErrNotTextBin) {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if errors.Is(err, store.ErrAliasTaken) {
+				writeJSONError(w, http.StatusConflict, "alias already taken")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "updating bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[update bin] something went wrong")
 				return
 			}
 
-			err = json.NewEncoder(w).Encode(bin)
+			err = json.NewEncoder(w).Encode(bin.Public())
 			if err != nil {
-				fmt.Fprintf(w, "%v", err.Error())
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
 			}
 		}
 
+		// deleteBinsByID deletes a bin of the caller, or any bin for
+		// moderators, along with its files.
 		deleteBinsByID := func(w http.ResponseWriter, r *http.Request) {
-			binID := chi.URLParam(r, "binID")
+			if !can(r.Context(), permDeleteAnyBin) {
+				if _, ok := loadEditableBin(w, r, svc); !ok {
+					return
+				}
+			}
 
-			bin, err := svc.DeleteBinByID(r.Context(), binID)
+			bin, err := deleteBin(r.Context(), svc, blobs, chi.URLParam(r, "binID"))
+			if errors.Is(err, store.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "bin not found")
+				return
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-
+				slog.ErrorContext(r.Context(), "deleting bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[delete bin] something went wrong")
 				return
 			}
 
-			err = json.NewEncoder(w).Encode(bin)
+			err = json.NewEncoder(w).Encode(bin.Public())
 			if err != nil {
-				fmt.Fprintf(w, "%v", err.Error())
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
 			}
 		}
 
 		getBins := func(w http.ResponseWriter, r *http.Request) {
-			bins, err := svc.GetAllBins(r.Context())
+			all, err := svc.GetAllBins(r.Context())
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "listing bins", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[bins] something went wrong")
 
 				return
 			}
 
-			err = json.NewEncoder(w).Encode(bins)
+			bins := []store.Bin{}
+			for _, bin := range all {
+				if isListed(r.Context(), &bin) {
+					bins = append(bins, bin)
+				}
+			}
+
+			err = json.NewEncoder(w).Encode(store.PublicBins(bins))
 			if err != nil {
-				fmt.Fprintf(w, "%v", err.Error())
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
 			}
 		}
 
-		createBin := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-
-			// Log the incoming request for debugging
-			log.Println("Received request to createBin")
+		// storeUpload records freshly streamed blobs as a bin, removing
+		// the blobs again when the bin cannot be saved.
+		storeUpload := func(w http.ResponseWriter, r *http.Request, bin *store.Bin) {
+			bin.Owner = currentUser(r.Context())
+			if bin.Alias == "" {
+				bin.Alias = newAlias()
+			}
 
-			err := r.ParseMultipartForm(10 << 20)
+			err := checkAlias(bin.Alias)
 			if err != nil {
-				log.Println("Error parsing form:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("Error parsing form")
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeUploadFailure(w, err, err.Error())
 				return
 			}
 
-			bin := &store.Bin{}
-
-			// Get alias
-			bin.Alias = r.FormValue("Alias")
-			log.Println("Alias received:", bin.Alias)
-
-			// Get file
-			f, handler, err := r.FormFile("Contain")
+			err = checkVisibility(bin)
 			if err != nil {
-				log.Println("Error getting file:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[get file] something went wrong")
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeUploadFailure(w, err, err.Error())
 				return
 			}
-			defer f.Close()
-
-			log.Println("Received file:", handler.Filename)
 
-			// Get file extension
-			fileExtension := strings.ToLower(filepath.Ext(handler.Filename))
-			log.Println("File extension:", fileExtension)
+			err = checkQuota(r.Context(), svc, opts.Quota, bin.Size)
+			if err != nil {
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeQuotaError(w, r, err)
+				return
+			}
 
-			// Create folders
-			path := filepath.Join(".", "files")
-			if err := os.MkdirAll(path, os.ModePerm); err != nil {
-				log.Println("Error creating directories:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[mkdir] something went wrong")
+			created, err := svc.CreateBin(r.Context(), *bin)
+			if errors.Is(err, store.ErrAliasTaken) {
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeJSONError(w, http.StatusConflict, "alias already taken")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "saving bin to database", "err", err)
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeJSONError(w, http.StatusInternalServerError, "[save bin] something went wrong")
 				return
 			}
 
-			fullPath := filepath.Join(path, bin.Alias+fileExtension)
-			log.Println("File will be saved to:", fullPath)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(created.Public())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
+			}
+		}
 
-			// Open and copy files
-			file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, os.ModePerm)
+		// createBin streams a multipart upload straight into blob storage,
+		// without buffering the form in memory or in temporary files.
+		// Several Contain parts make a multi-file bin, a Contain field
+		// without a file name makes a text bin.
+		createBin := func(w http.ResponseWriter, r *http.Request) {
+			limit, err := uploadLimit(r.Context(), svc, opts.Quota, opts.MaxUploadSize)
 			if err != nil {
-				log.Println("Error opening file:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[open file] something went wrong")
+				writeQuotaError(w, r, err)
 				return
 			}
-			defer file.Close()
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
 
-			_, err = io.Copy(file, f)
+			reader, err := r.MultipartReader()
 			if err != nil {
-				log.Println("Error copying file:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[copy file] something went wrong")
+				slog.WarnContext(r.Context(), "reading form", "err", err)
+				writeJSONError(w, http.StatusBadRequest, "Error parsing form")
 				return
 			}
 
-			bin.Contain = fullPath
-			log.Println("File saved successfully:", fullPath)
+			bin := &store.Bin{}
+			text := ""
+
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err == nil {
+					switch part.FormName() {
+					case "Alias":
+						bin.Alias, err = readField(part)
+					case "Title":
+						bin.Title, err = readField(part)
+					case "Visibility":
+						bin.Visibility, err = readField(part)
+					case "Tags":
+						var tags string
+						tags, err = readField(part)
+						if err == nil {
+							bin.Tags, err = parseTags(tags)
+						}
+					case "Expiry", "BurnAfterRead", "Password", "Language":
+						var value string
+						value, err = readField(part)
+						if err == nil {
+							err = setBinOption(bin, part.FormName(), value)
+						}
+					case "Contain":
+						if part.FileName() == "" {
+							text, err = readTextField(opts.Policy, part)
+							break
+						}
+						slog.DebugContext(r.Context(), "receiving file", "name", part.FileName())
+						var file *store.BinFile
+						file, err = saveFile(r.Context(), blobs, opts.Policy, bin.Files, part.FileName(), part)
+						if file != nil {
+							bin.Files = append(bin.Files, *file)
+						}
+					}
+					part.Close()
+				}
+
+				if err != nil {
+					slog.WarnContext(r.Context(), "reading upload", "err", err)
+					deleteFiles(r.Context(), blobs, bin.Files)
+					writeUploadFailure(w, err, "[get file] "+err.Error())
+					return
+				}
+			}
 
-			bin, err = svc.CreateBin(r.Context(), *bin)
-			if err != nil {
-				log.Println("Error saving bin to database:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[save bin] something went wrong")
+			if text != "" && len(bin.Files) != 0 {
+				deleteFiles(r.Context(), blobs, bin.Files)
+				writeJSONError(w, http.StatusBadRequest, "[get file] a bin holds either text or files")
 				return
 			}
 
-			w.WriteHeader(http.StatusCreated)
-			err = json.NewEncoder(w).Encode(bin)
-			if err != nil {
-				log.Println("Error encoding response:", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode("[encode response] something went wrong")
+			if text != "" {
+				bin.Contain = text
+				bin.ContentType = "text/plain"
+				bin.Size = int64(len(text))
+				storeUpload(w, r, bin)
+				return
+			}
+
+			if len(bin.Files) == 0 {
+				writeJSONError(w, http.StatusBadRequest, "[get file] no file provided")
 				return
 			}
+
+			// the first file stays reachable through /bins/file/{alias}
+			bin.Contain = bin.Files[0].Key
+			bin.Filename = bin.Files[0].Name
+			describeFiles(bin)
+
+			slog.DebugContext(r.Context(), "saved files", "count", len(bin.Files))
+			storeUpload(w, r, bin)
 		}
 
-		getStats := func(w http.ResponseWriter, r *http.Request) {
-			statistics, err := svc.GetStats(r.Context())
+		// uploadRawBin stores the raw request body as the file of a new bin,
+		// for clients such as `curl -T file http://host/bins/alias`.
+		uploadRawBin := func(w http.ResponseWriter, r *http.Request) {
+			err := checkAlias(chi.URLParam(r, "binID"))
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeUploadFailure(w, err, err.Error())
 				return
 			}
 
-			err = json.NewEncoder(w).Encode(statistics)
+			limit, err := uploadLimit(r.Context(), svc, opts.Quota, opts.MaxUploadSize)
 			if err != nil {
-				fmt.Fprintf(w, "%v", err.Error())
+				writeQuotaError(w, r, err)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+			bin := &store.Bin{
+				Alias:      chi.URLParam(r, "binID"),
+				Title:      r.URL.Query().Get("title"),
+				Visibility: r.URL.Query().Get("visibility"),
+				Filename:   cleanFilename(r.URL.Query().Get("filename")),
+			}
+
+			if bin.Filename == "" {
+				bin.Filename = bin.Alias + extensionFor(r.Header.Get("Content-Type"))
 			}
-		}
 
-		getUsers := func(w http.ResponseWriter, r *http.Request) {
-			users, err := svc.GetAllUsers(r.Context())
+			tags, err := parseTags(r.URL.Query().Get("tags"))
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				writeJSONError(w, http.StatusBadRequest, err.Error())
 				return
 			}
+			bin.Tags = tags
 
-			w.Header().Set("Content-Type", "application/json")
-			if err := json.NewEncoder(w).Encode(users); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			file, err := saveFile(r.Context(), blobs, opts.Policy, nil, bin.Filename, r.Body)
+			if err != nil {
+				slog.WarnContext(r.Context(), "reading upload", "err", err)
+				writeUploadFailure(w, err, "[copy file] something went wrong")
 				return
 			}
+			bin.Contain = file.Key
+			bin.Files = []store.BinFile{*file}
+			describeFiles(bin)
+
+			storeUpload(w, r, bin)
 		}
 
-		inscriptionUtilisateur := func(w http.ResponseWriter, r *http.Request) {
-			var user store.User
-			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		// putBin updates a bin when given JSON, otherwise treats the body
+		// as a raw file upload under the alias in the URL.
+		putBin := func(w http.ResponseWriter, r *http.Request) {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType == "application/json" {
+				updateBinByID(w, r)
 				return
 			}
 
-			existingUser, err := svc.GetUserByEmail(r.Context(), user.Email)
-			if err == nil && existingUser != nil {
-				http.Error(w, "Email already exists", http.StatusConflict)
-				return
-			}
+			uploadRawBin(w, r)
+		}
 
-			newUser := store.User{
-				Email:      user.Email,
-				MotDePasse: user.MotDePasse,
+		// getStats returns the global statistics, with the ?top= most
+		// viewed bins.
+		getStats := func(w http.ResponseWriter, r *http.Request) {
+			top := defaultStatsTop
+			if r.URL.Query().Get("top") != "" {
+				n, err := strconv.Atoi(r.URL.Query().Get("top"))
+				if err != nil || n < 1 || n > maxStatsTop {
+					writeJSONError(w, http.StatusBadRequest, "top must be between 1 and "+strconv.Itoa(maxStatsTop))
+					return
+				}
+				top = n
 			}
 
-			_, err = svc.CreateUser(r.Context(), newUser)
+			statistics, err := svc.GetStats(r.Context(), top)
 			if err != nil {
-				http.Error(w, "Failed to create user", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "loading statistics", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[statistics] something went wrong")
 				return
 			}
 
-			w.WriteHeader(http.StatusCreated)
-			w.Write([]byte("User created successfully"))
+			err = json.NewEncoder(w).Encode(statistics)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "encoding response", "err", err)
+			}
 		}
 
-		connexionUtilisateur := func(w http.ResponseWriter, r *http.Request, secretKey []byte) {
-			var user store.User
-			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		inscriptionUtilisateur := func(w http.ResponseWriter, r *http.Request) {
+			var creds credentials
+			if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
 				return
 			}
 
-			storedUser, err := svc.GetUserByEmail(r.Context(), user.Email)
-			if err != nil {
-				http.Error(w, "Invalid email", http.StatusUnauthorized)
+			email, ok := normalizeEmail(creds.Email)
+			if !ok {
+				writeJSONError(w, http.StatusBadRequest, "invalid email")
 				return
 			}
 
-			if err := bcrypt.CompareHashAndPassword([]byte(storedUser.MotDePasse), []byte(user.MotDePasse)); err != nil {
-				http.Error(w, "Invalid password", http.StatusUnauthorized)
+			if msg := opts.PasswordPolicy.check(creds.Password, email); msg != "" {
+				writeJSONError(w, http.StatusBadRequest, msg)
 				return
 			}
 
-			// Génère un jeton JWT pour l'utilisateur authentifié
-			token := jwt.New(jwt.SigningMethodHS256)
-			claims := token.Claims.(jwt.MapClaims)
-			claims["email"] = user.Email
-			claims["exp"] = time.Now().Add(time.Hour * 24).Unix() // Expiration dans 24 heures
-
-			tokenString, err := token.SignedString([]byte(secretKey))
-			if err != nil {
-				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			existingUser, err := svc.GetUserByEmail(r.Context(), email)
+			if err == nil && existingUser != nil {
+				writeJSONError(w, http.StatusConflict, "Email already exists")
 				return
 			}
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
-		}
+			newUser := store.User{
+				Email:    email,
+				Password: creds.Password,
+				Role:     store.RoleUser,
+			}
+			if isAdminEmail(opts, email) {
+				newUser.Role = store.RoleAdmin
+			}
 
-		dropAllUsers := func(w http.ResponseWriter, r *http.Request) {
-			err := svc.DropAllUsers(r.Context())
+			created, err := svc.CreateUser(r.Context(), newUser)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "creating user", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "Failed to create user")
 				return
 			}
 
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("All users dropped successfully"))
+			mailToken(r.Context(), svc, opts, store.PurposeVerifyEmail, created.Email)
+			writeJSON(w, http.StatusCreated, created.Public())
 		}
 
+		router.Use(realIP(proxies))
+		router.Use(tracing.Middleware)
+		router.Use(logging.RequestIDMiddleware)
+		router.Use(logging.AccessLog)
+		router.Use(metrics.Middleware)
+		router.Use(authenticate(opts.SecretKey, svc))
+		router.With(requireScope(store.ScopeAdmin), requirePermission(permViewMetrics)).Handle("/metrics", metrics.Handler())
+		router.Get("/healthz", opts.Health.Liveness)
+		router.Get("/readyz", opts.Health.Readiness)
 		router.Route("/", func(r chi.Router) {
+			r.Use(rateLimit(svc, opts.RateLimit))
+			r.Use(requireMethodScope)
 			r.Post("/bins", createBin)
 			r.Get("/bins", getBins)
 			r.Get("/bins/statistics", getStats)
 			r.Get("/bins/{alias}", getBinByAlias)
+			r.Get("/bins/{alias}/files/{name}", getBinFile(svc, blobs, views))
+			r.Get("/bins/{alias}/archive", getBinArchive(svc, blobs, views))
+			r.Get("/bins/{alias}/stats", getBinStats(svc))
+			r.Get("/bins/{alias}/thumbnail", getBinThumbnail(svc, blobs))
+			r.Get("/search", searchBins(svc, index))
+			r.Post("/bins/{binID}/tags", addBinTags(svc))
+			r.Delete("/bins/{binID}/tags/{tag}", removeBinTag(svc))
+			r.Get("/tags", getTags(svc))
+			r.Get("/tags/{tag}/bins", getBinsByTag(svc))
+			r.Route("/collections", func(r chi.Router) {
+				r.Use(requireUser)
+				r.Post("/", createCollection(svc))
+				r.Get("/", getCollections(svc))
+				r.Get("/{collectionID}", getCollection(svc))
+				r.Put("/{collectionID}", updateCollection(svc))
+				r.Delete("/{collectionID}", deleteCollection(svc))
+				r.Post("/{collectionID}/bins", addCollectionBins(svc))
+				r.Delete("/{collectionID}/bins/{binID}", removeCollectionBin(svc))
+			})
 			r.Get("/bins/file/{alias}", getFileByAlias)
-			r.Put("/bins/{binID}", updateBinByID)
+			r.Put("/bins/{binID}", putBin)
 			r.Delete("/bins/{binID}", deleteBinsByID)
-			r.Get("/users", getUsers)
+			r.With(requireScope(store.ScopeAdmin), requirePermission(permViewUsers)).Get("/users", listUsers(svc))
 			r.Post("/users/auth", inscriptionUtilisateur)
-			r.Post("/users/login", func(w http.ResponseWriter, r *http.Request) {
-				connexionUtilisateur(w, r, secretKey)
+			r.Post("/users/login", login(svc, opts))
+			r.Post("/users/login/2fa", loginSecondFactor(svc, opts))
+			r.Post("/users/refresh", refreshSession(svc, opts))
+			r.Post("/users/verify", verifyEmail(svc))
+			r.Post("/users/verify/resend", resendVerification(svc, opts))
+			r.Post("/users/password/forgot", forgotPassword(svc, opts))
+			r.Post("/users/password/reset", resetPassword(svc, opts))
+			r.With(requireUser).Post("/users/logout", logout(svc))
+			r.With(requireSession).Post("/users/logout/all", logoutAll(svc))
+			r.With(requireUser).Get("/users/me/usage", getUsage(svc, opts.Quota))
+			r.Route("/users/me/sessions", func(r chi.Router) {
+				r.Use(requireSession)
+				r.Get("/", getSessions(svc))
+				r.Delete("/{sessionID}", deleteSession(svc))
+			})
+			r.Route("/users/me/2fa", func(r chi.Router) {
+				r.Use(requireSession)
+				r.Post("/", enrollTwoFactor(svc, opts))
+				r.Delete("/", disableTwoFactor(svc))
+				r.Post("/verify", verifyTwoFactor(svc))
+				r.Post("/recovery-codes", regenerateRecoveryCodes(svc))
+			})
+			r.Route("/users/me/tokens", func(r chi.Router) {
+				r.Use(requireSession)
+				r.Post("/", createToken(svc))
+				r.Get("/", getTokens(svc))
+				r.Delete("/{tokenID}", deleteToken(svc))
+			})
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(requireScope(store.ScopeAdmin))
+				r.With(requirePermission(permViewUsers)).Get("/users", listUsers(svc))
+				r.With(requirePermission(permViewUsers)).Get("/users/{email}", getUser(svc))
+				r.With(requirePermission(permManageUsers)).Patch("/users/{email}", updateUser(svc))
+				r.With(requirePermission(permManageUsers)).Delete("/users/{email}", deleteUser(svc))
+				r.With(requirePermission(permDeleteAnyBin)).Delete("/bins/{binID}", deleteAnyBin(svc, blobs))
+			})
+			r.Route("/uploads", func(r chi.Router) {
+				r.Use(requireTus)
+				r.Options("/", optionsUpload(opts))
+				r.Post("/", createUpload(svc, blobs, opts))
+				r.Head("/{uploadID}", headUpload(svc))
+				r.Patch("/{uploadID}", patchUpload(svc, blobs, opts))
+				r.Delete("/{uploadID}", terminateUpload(svc, blobs))
 			})
-			r.Post("/users/drop-all-users", dropAllUsers)
 		})
 
 		handler := c.Handler(router)
 
-		address := ":4000" // Vous pouvez aussi utiliser flag ou cli pour permettre de configurer l'adresse
-
-		log.Printf("Listening on %s", address)
-		err := http.ListenAndServe(address, handler)
-		if err != nil {
-			return err
+		server := &http.Server{
+			Addr:              opts.Server.Addr,
+			Handler:           handler,
+			ReadHeaderTimeout: opts.Server.ReadHeaderTimeout,
+			ReadTimeout:       opts.Server.ReadTimeout,
+			WriteTimeout:      opts.Server.WriteTimeout,
+			IdleTimeout:       opts.Server.IdleTimeout,
+			ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
 		}
 
-		return nil
+		return serve(ctx, server, opts)
 	}
 }
@@ -0,0 +1,238 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/store"
+	"pastebin/thumbnail"
+)
+
+// serveFile sends one file of a bin as a download. JPEG and PNG images
+// go through thumbnail.StripMetadata so the EXIF data of the original
+// upload is never served.
+func serveFile(w http.ResponseWriter, r *http.Request, blobs blob.Storage, file store.BinFile) {
+	f, err := blobs.Open(r.Context(), file.Key)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "file not exist")
+		return
+	}
+	defer f.Close()
+
+	// force a download with the content- disposition field
+	w.Header().Set("Content-Disposition", attachment(file.Name))
+
+	contentType, err := sniffContentType(f)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "file not exist")
+		return
+	}
+
+	if !hasMetadata(contentType) {
+		http.ServeContent(w, r, file.Name, f.ModTime(), f)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Last-Modified", f.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	err = thumbnail.StripMetadata(w, f)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "serving image", "err", err)
+	}
+}
+
+// attachment is a Content-Disposition value making clients download a
+// file named name, quoted or encoded as its characters require.
+func attachment(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
+
+// sniffContentType detects the content type of f from its first bytes,
+// then rewinds it.
+func sniffContentType(f io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(head[:n]), nil
+}
+
+// hasMetadata reports whether files of contentType must go through
+// thumbnail.StripMetadata before being served.
+func hasMetadata(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// pickFile returns the file of a bin named by the "file" query parameter,
+// or its first file.
+func pickFile(r *http.Request, bin *store.Bin) (store.BinFile, bool) {
+	files := binFiles(bin)
+	if len(files) == 0 {
+		return store.BinFile{}, false
+	}
+
+	name := r.URL.Query().Get("file")
+	if name == "" {
+		return files[0], true
+	}
+
+	for _, file := range files {
+		if file.Name == name {
+			return file, true
+		}
+	}
+
+	return store.BinFile{}, false
+}
+
+// thumbnailKey is where the thumbnail of a file is cached in blob storage.
+func thumbnailKey(file store.BinFile, size string) string {
+	return "thumbnails/" + file.Key + "-" + size
+}
+
+// deleteThumbnails removes the cached thumbnails of files, in every size.
+func deleteThumbnails(ctx context.Context, blobs blob.Storage, files []store.BinFile) {
+	for _, file := range files {
+		for size := range thumbnail.Sizes {
+			key := thumbnailKey(file, size)
+			err := blobs.Delete(ctx, key)
+			if err != nil {
+				slog.ErrorContext(ctx, "deleting thumbnail", "key", key, "err", err)
+			}
+		}
+	}
+}
+
+// getBinThumbnail serves a thumbnail of an image bin, generating it on
+// first request and caching it in blob storage. Decoding an image takes
+// memory and CPU, at most one thumbnail per CPU is generated at a time.
+func getBinThumbnail(svc store.Store, blobs blob.Storage) http.HandlerFunc {
+	slots := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := r.URL.Query().Get("size")
+		if size == "" {
+			size = "small"
+		}
+
+		px, ok := thumbnail.Sizes[size]
+		if !ok {
+			sizes := []string{}
+			for name := range thumbnail.Sizes {
+				sizes = append(sizes, name)
+			}
+			sort.Strings(sizes)
+			writeJSONError(w, http.StatusBadRequest, "size must be one of "+strings.Join(sizes, ", "))
+			return
+		}
+
+		bin, err := svc.PeekBinByAlias(r.Context(), chi.URLParam(r, "alias"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[thumbnail] something went wrong")
+			return
+		}
+
+		if !canView(r.Context(), bin) {
+			writeJSONError(w, http.StatusNotFound, "bin not found")
+			return
+		}
+
+		if isLocked(r, bin) {
+			writeJSONError(w, http.StatusUnauthorized, "password required")
+			return
+		}
+
+		if isTextBin(bin) {
+			writeJSONError(w, http.StatusNotFound, "bin has no files")
+			return
+		}
+
+		// a thumbnail would outlive a bin meant to be read once
+		if bin.BurnAfterRead {
+			writeJSONError(w, http.StatusNotFound, "no thumbnail for burn after read bins")
+			return
+		}
+
+		file, ok := pickFile(r, bin)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "file not found in bin")
+			return
+		}
+
+		if bin.Protected || bin.Visibility == store.VisibilityPrivate {
+			w.Header().Set("Cache-Control", "private, max-age=86400")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+		}
+
+		key := thumbnailKey(file, size)
+		cached, err := blobs.Open(r.Context(), key)
+		if err == nil {
+			defer cached.Close()
+			http.ServeContent(w, r, "", cached.ModTime(), cached)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-r.Context().Done():
+			return
+		}
+
+		// another request may have generated it while this one waited
+		cached, err = blobs.Open(r.Context(), key)
+		if err == nil {
+			defer cached.Close()
+			http.ServeContent(w, r, "", cached.ModTime(), cached)
+			return
+		}
+
+		src, err := blobs.Open(r.Context(), file.Key)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "file not exist")
+			return
+		}
+		defer src.Close()
+
+		var buf bytes.Buffer
+		_, err = thumbnail.Generate(&buf, src, px)
+		if err != nil {
+			slog.WarnContext(r.Context(), "generating thumbnail", "bin", bin.ID, "file", file.Name, "err", err)
+			writeJSONError(w, http.StatusUnsupportedMediaType, "file is not a supported image")
+			return
+		}
+
+		_, err = blobs.Put(r.Context(), key, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "caching thumbnail", "bin", bin.ID, "err", err)
+		}
+
+		http.ServeContent(w, r, "", time.Now(), bytes.NewReader(buf.Bytes()))
+	}
+}
@@ -0,0 +1,149 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/metrics"
+	"pastebin/store"
+	"pastebin/thumbnail"
+)
+
+// orphanGrace is how old a blob no bin refers to must be before the
+// janitor removes it, leaving time to uploads to save their bin.
+const orphanGrace = time.Hour
+
+// RunJanitor removes abandoned upload sessions and their partial data,
+// and the blobs of bins deleted or expired by Redis, every interval,
+// until ctx is cancelled.
+func RunJanitor(ctx context.Context, svc store.Store, blobs blob.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := sweepUploads(ctx, svc, blobs)
+			if err != nil {
+				slog.ErrorContext(ctx, "sweeping uploads", "err", err)
+			}
+
+			orphansErr := sweepOrphans(ctx, svc, blobs)
+			if orphansErr != nil {
+				slog.ErrorContext(ctx, "sweeping orphaned blobs", "err", orphansErr)
+				err = orphansErr
+			}
+			metrics.JanitorRun(err)
+		}
+	}
+}
+
+func sweepUploads(ctx context.Context, svc store.Store, blobs blob.Storage) error {
+	uploads, err := svc.GetExpiredUploads(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		err = blobs.Delete(ctx, uploadBlobKey(upload.ID))
+		if err != nil {
+			return err
+		}
+
+		err = svc.DeleteUpload(ctx, upload.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(uploads) != 0 {
+		slog.InfoContext(ctx, "removed expired uploads", "count", len(uploads))
+	}
+
+	return nil
+}
+
+// isGeneratedKey reports whether key is a blob the server names itself:
+// an uploaded file, a thumbnail of one or the data of an upload session,
+// all named after a UUID. Other files under the root, put there by hand
+// or by older versions, cannot be told apart from data still in use.
+func isGeneratedKey(key string) bool {
+	if rest, ok := strings.CutPrefix(key, "thumbnails/"); ok {
+		i := strings.LastIndex(rest, "-")
+		if i < 0 {
+			return false
+		}
+		key = rest[:i]
+	} else if id, ok := strings.CutPrefix(key, uploadBlobKey("")); ok {
+		key = id
+	}
+
+	_, err := uuid.Parse(strings.TrimSuffix(key, path.Ext(key)))
+	return err == nil
+}
+
+// sweepOrphans removes the blobs no bin, thumbnail or upload session
+// refers to anymore, left by bins Redis expired or deleted without
+// their files. Only blobs the server named are removed.
+func sweepOrphans(ctx context.Context, svc store.Store, blobs blob.Storage) error {
+	bins, err := svc.GetAllBins(ctx)
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for i := range bins {
+		for _, file := range binFiles(&bins[i]) {
+			used[file.Key] = true
+			for size := range thumbnail.Sizes {
+				used[thumbnailKey(file, size)] = true
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-orphanGrace)
+	orphans := []string{}
+	err = blobs.Walk(ctx, func(key string, modTime time.Time) error {
+		if used[key] || modTime.After(cutoff) || !isGeneratedKey(key) {
+			return nil
+		}
+
+		if id, ok := strings.CutPrefix(key, uploadBlobKey("")); ok {
+			_, err := svc.GetUpload(ctx, id)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+		}
+
+		orphans = append(orphans, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range orphans {
+		err = blobs.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(orphans) != 0 {
+		slog.InfoContext(ctx, "removed orphaned blobs", "count", len(orphans))
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"pastebin/store"
+)
+
+// LoginProtection slows down password guessing. Past FreeAttempts
+// failures, each new attempt of an IP or an account waits BaseDelay,
+// doubled at every failure. Accounts are locked after MaxAttempts
+// failures and IPs after IPMaxAttempts, until Lockout after the last one.
+type LoginProtection struct {
+	FreeAttempts  int64
+	MaxAttempts   int64
+	IPMaxAttempts int64
+	BaseDelay     time.Duration
+	Lockout       time.Duration
+}
+
+// loginFailed is the only answer to a failed login, so it tells nothing
+// about which accounts exist.
+const loginFailed = "invalid email or password"
+
+// retryAfter returns how long failures hold off the next attempt, given
+// the failures allowed before a lockout.
+func (p LoginProtection) retryAfter(failures *store.LoginFailures, max int64) time.Duration {
+	var wait time.Duration
+	switch {
+	case failures.Count >= max:
+		wait = p.Lockout
+	case failures.Count > p.FreeAttempts:
+		wait = p.BaseDelay << (failures.Count - p.FreeAttempts - 1)
+		if wait <= 0 || wait > p.Lockout {
+			wait = p.Lockout
+		}
+	}
+
+	return time.Until(failures.Last.Add(wait))
+}
+
+// clientIP returns the IP address of the caller, as set by realIP behind
+// trusted proxies.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummy spends the time of a password check, so that unknown
+// emails answer as slowly as wrong passwords.
+func compareDummy(ctx context.Context, password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+
+	checkPassword(ctx, string(dummyHash), password)
+}
+
+// login opens a session for the user with the given credentials. Users
+// with two-factor authentication get a challenge to answer at
+// /users/login/2fa instead.
+func login(svc store.Store, opts Options) http.HandlerFunc {
+	protection := opts.LoginProtection
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds credentials
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		email, _ := normalizeEmail(creds.Email)
+
+		attempt := reserveLoginAttempt(w, r, svc, protection, email)
+		if attempt == nil {
+			return
+		}
+
+		failed := false
+		defer func() {
+			if !failed {
+				attempt.refund(r.Context(), svc)
+			}
+		}()
+
+		var user *store.User
+		var err error
+		if email != "" {
+			user, err = svc.GetUserByEmail(r.Context(), email)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				slog.ErrorContext(r.Context(), "loading user", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+				return
+			}
+		}
+
+		if user == nil {
+			compareDummy(r.Context(), creds.Password)
+		}
+
+		if user == nil || !checkPassword(r.Context(), user.PasswordHash, creds.Password) {
+			failed = true
+			attempt.warnLockout(r.Context(), protection)
+			writeJSONError(w, http.StatusUnauthorized, loginFailed)
+			return
+		}
+
+		if user.Disabled {
+			writeJSONError(w, http.StatusForbidden, "account disabled")
+			return
+		}
+
+		if opts.RequireVerifiedEmail && !user.EmailVerified {
+			writeJSONError(w, http.StatusForbidden, "email not verified")
+			return
+		}
+
+		if user.TOTPEnabled {
+			writeLoginChallenge(w, r, svc, user.Email)
+			return
+		}
+
+		err = svc.ClearLoginFailures(r.Context(), attempt.accountSubject)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "clearing login failures", "err", err)
+		}
+
+		startSession(w, r, svc, opts, user.Email)
+	}
+}
+
+// loginAttempt is an attempt to log in to an account, counted as failed
+// against the IP of the caller and the account until it is refunded.
+type loginAttempt struct {
+	ipSubject, accountSubject   string
+	ipFailures, accountFailures *store.LoginFailures
+}
+
+// reserveLoginAttempt counts an attempt of the caller on the account of
+// email as failed before its credentials are checked, so concurrent
+// attempts cannot all pass the check before a failure. It answers the
+// request and returns nil when the attempt is held off or cannot be
+// counted.
+func reserveLoginAttempt(w http.ResponseWriter, r *http.Request, svc store.Store, protection LoginProtection, email string) *loginAttempt {
+	attempt := &loginAttempt{ipSubject: "ip:" + clientIP(r), accountSubject: "account:" + email}
+
+	var err error
+	attempt.ipFailures, err = svc.ReserveLoginAttempt(r.Context(), attempt.ipSubject, protection.Lockout)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reserving login attempt", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+		return nil
+	}
+
+	attempt.accountFailures, err = svc.ReserveLoginAttempt(r.Context(), attempt.accountSubject, protection.Lockout)
+	if err != nil {
+		refundLoginAttempt(r.Context(), svc, attempt.ipSubject, attempt.ipFailures)
+		slog.ErrorContext(r.Context(), "reserving login attempt", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+		return nil
+	}
+
+	wait := protection.retryAfter(attempt.ipFailures, protection.IPMaxAttempts)
+	if accountWait := protection.retryAfter(attempt.accountFailures, protection.MaxAttempts); accountWait > wait {
+		wait = accountWait
+	}
+	if wait > 0 {
+		attempt.refund(r.Context(), svc)
+		w.Header().Set("Retry-After", seconds(wait))
+		writeJSONError(w, http.StatusTooManyRequests, "too many failed logins, retry later")
+		return nil
+	}
+
+	return attempt
+}
+
+// refund uncounts an attempt that did not fail.
+func (a *loginAttempt) refund(ctx context.Context, svc store.Store) {
+	refundLoginAttempt(ctx, svc, a.ipSubject, a.ipFailures)
+	refundLoginAttempt(ctx, svc, a.accountSubject, a.accountFailures)
+}
+
+// warnLockout logs the lockouts a failed attempt caused.
+func (a *loginAttempt) warnLockout(ctx context.Context, protection LoginProtection) {
+	warnLockout(ctx, a.ipSubject, a.ipFailures.Count+1, protection.IPMaxAttempts)
+	warnLockout(ctx, a.accountSubject, a.accountFailures.Count+1, protection.MaxAttempts)
+}
+
+// warnLockout logs the failure locking subject out.
+func warnLockout(ctx context.Context, subject string, count, max int64) {
+	if count == max {
+		slog.WarnContext(ctx, "login locked out", "subject", subject, "failures", count)
+	}
+}
+
+// refundLoginAttempt uncounts a reserved attempt that did not fail.
+func refundLoginAttempt(ctx context.Context, svc store.Store, subject string, failures *store.LoginFailures) {
+	err := svc.RefundLoginAttempt(ctx, subject, failures)
+	if err != nil {
+		slog.ErrorContext(ctx, "refunding login attempt", "err", err)
+	}
+}
@@ -0,0 +1,209 @@
+package domain
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sniffLen is how many leading bytes are used to detect a content type.
+const sniffLen = 512
+
+// UploadPolicy restricts which content types can be uploaded and how
+// large they can be. Types are media types such as "image/png" or
+// wildcards such as "image/*". An empty Allowed list allows every type
+// that is not Denied.
+type UploadPolicy struct {
+	Allowed  []string
+	Denied   []string
+	MaxSizes map[string]int64
+}
+
+// policyError is an upload refused by the UploadPolicy or another
+// upload rule, answered with status.
+type policyError struct {
+	status int
+	msg    string
+}
+
+func (e *policyError) Error() string {
+	return e.msg
+}
+
+// ParseUploadPolicy builds a policy from comma separated type lists and
+// a comma separated list of type=bytes size caps.
+func ParseUploadPolicy(allowed, denied, maxSizes string) (UploadPolicy, error) {
+	policy := UploadPolicy{
+		Allowed:  splitList(allowed),
+		Denied:   splitList(denied),
+		MaxSizes: map[string]int64{},
+	}
+
+	for _, entry := range splitList(maxSizes) {
+		pattern, size, ok := strings.Cut(entry, "=")
+		if !ok {
+			return policy, errors.Errorf("invalid size cap %q, expected type=bytes", entry)
+		}
+
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			return policy, errors.Errorf("invalid size in size cap %q", entry)
+		}
+
+		policy.MaxSizes[strings.ToLower(pattern)] = n
+	}
+
+	return policy, nil
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// matchType reports how specifically pattern matches contentType: 0 for
+// no match, 1 for "*", 2 for "image/*" and 3 for an exact type.
+func matchType(pattern, contentType string) int {
+	switch {
+	case pattern == contentType:
+		return 3
+	case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*")):
+		return 2
+	case pattern == "*" || pattern == "*/*":
+		return 1
+	}
+
+	return 0
+}
+
+func matchAny(patterns []string, contentType string) bool {
+	for _, pattern := range patterns {
+		if matchType(pattern, contentType) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check refuses content types that are denied or not allowed.
+func (p UploadPolicy) check(contentType string) error {
+	if matchAny(p.Denied, contentType) || (len(p.Allowed) != 0 && !matchAny(p.Allowed, contentType)) {
+		return &policyError{
+			status: http.StatusUnsupportedMediaType,
+			msg:    "content type " + contentType + " is not allowed",
+		}
+	}
+
+	return nil
+}
+
+// maxSize returns the size cap of the most specific matching pattern,
+// or 0 when the type is not capped.
+func (p UploadPolicy) maxSize(contentType string) int64 {
+	best, size := 0, int64(0)
+	for pattern, n := range p.MaxSizes {
+		score := matchType(pattern, contentType)
+		if score > best {
+			best, size = score, n
+		}
+	}
+
+	return size
+}
+
+// textTypes are declared types that http.DetectContentType reports as
+// plain text.
+var textTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/yaml":       true,
+	"application/toml":       true,
+}
+
+// zipTypes are declared types stored in a zip container.
+var zipTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"application/java-archive":     true,
+	"application/epub+zip":         true,
+}
+
+func isTextType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || textTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json")
+}
+
+func isZipType(mediaType string) bool {
+	return zipTypes[mediaType] ||
+		strings.HasPrefix(mediaType, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument.")
+}
+
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	if mediaType == "application/x-gzip" {
+		return "application/gzip"
+	}
+
+	return mediaType
+}
+
+// opaqueTypes are declared types that http.DetectContentType has no
+// signature for and reports as application/octet-stream.
+var opaqueTypes = map[string]bool{
+	"application/x-tar":           true,
+	"application/x-7z-compressed": true,
+	"application/x-bzip2":         true,
+	"application/x-xz":            true,
+	"application/zstd":            true,
+	"application/vnd.sqlite3":     true,
+	"application/msword":          true,
+	"application/vnd.ms-excel":    true,
+	"audio/flac":                  true,
+	"image/avif":                  true,
+	"image/heic":                  true,
+}
+
+// detectContentType sniffs the type of an upload from its first bytes
+// and checks it against the type declared by its file extension. The
+// declared type is kept when sniffing can only tell the broad family,
+// such as plain text for a JSON file, or for the few types sniffing
+// cannot recognise at all.
+func detectContentType(filename string, head []byte) (string, error) {
+	sniffed := mediaTypeOf(http.DetectContentType(head))
+	declared := mediaTypeOf(mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))))
+
+	switch {
+	case declared == "" || declared == sniffed:
+		return sniffed, nil
+	case sniffed == "application/octet-stream" && opaqueTypes[declared]:
+		return declared, nil
+	case strings.HasPrefix(sniffed, "text/") && isTextType(declared):
+		return declared, nil
+	case sniffed == "application/zip" && isZipType(declared):
+		return declared, nil
+	}
+
+	return "", &policyError{
+		status: http.StatusUnsupportedMediaType,
+		msg:    "content of " + filename + " is " + sniffed + ", which does not match its extension (" + declared + ")",
+	}
+}
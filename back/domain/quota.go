@@ -0,0 +1,186 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// Quota bounds what each user stores: their bins, the bytes of all of
+// them and the bytes of one. Zero fields are no bound. Anonymous
+// uploads are only bound by the upload size and the rate limit.
+type Quota struct {
+	MaxBins    int64
+	MaxBytes   int64
+	MaxBinSize int64
+}
+
+// formSlack is added to the quota room when bounding an upload body,
+// for its form fields and multipart framing. The stored size is checked
+// exactly by checkQuota.
+const formSlack = 16 << 10
+
+// quotaRoom returns the bytes the caller may still store in a new bin,
+// -1 for no bound. It fails with a policyError when the caller has no
+// room left.
+func quotaRoom(ctx context.Context, svc store.Store, quota Quota) (int64, error) {
+	owner := currentUser(ctx)
+	if owner == "" {
+		return -1, nil
+	}
+
+	room := quota.MaxBinSize
+	if room == 0 {
+		room = -1
+	}
+
+	if quota.MaxBins == 0 && quota.MaxBytes == 0 {
+		return room, nil
+	}
+
+	usage, err := svc.GetUsage(ctx, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	if quota.MaxBins > 0 && usage.Bins >= quota.MaxBins {
+		return 0, &policyError{
+			status: http.StatusForbidden,
+			msg:    "bin quota reached, " + strconv.FormatInt(quota.MaxBins, 10) + " bins at most",
+		}
+	}
+
+	if quota.MaxBytes > 0 {
+		left := quota.MaxBytes - usage.Bytes
+		if left <= 0 {
+			return 0, &policyError{
+				status: http.StatusRequestEntityTooLarge,
+				msg:    "storage quota reached",
+			}
+		}
+		if room < 0 || left < room {
+			room = left
+		}
+	}
+
+	return room, nil
+}
+
+// uploadLimit returns the most bytes of request body the caller may
+// send now, within maxUpload.
+func uploadLimit(ctx context.Context, svc store.Store, quota Quota, maxUpload int64) (int64, error) {
+	room, err := quotaRoom(ctx, svc, quota)
+	if err != nil {
+		return 0, err
+	}
+
+	if room >= 0 && room+formSlack < maxUpload {
+		return room + formSlack, nil
+	}
+
+	return maxUpload, nil
+}
+
+// checkQuota fails with a policyError when the caller has no room for a
+// new bin of size bytes.
+func checkQuota(ctx context.Context, svc store.Store, quota Quota, size int64) error {
+	if currentUser(ctx) != "" && quota.MaxBinSize > 0 && size > quota.MaxBinSize {
+		return &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "bins are limited to " + strconv.FormatInt(quota.MaxBinSize, 10) + " bytes",
+		}
+	}
+
+	room, err := quotaRoom(ctx, svc, quota)
+	if err != nil {
+		return err
+	}
+
+	if room >= 0 && size > room {
+		return &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "bin exceeds the storage quota",
+		}
+	}
+
+	return nil
+}
+
+// checkResize fails with a policyError when the owner of bin has no room
+// for it to grow to size bytes.
+func checkResize(ctx context.Context, svc store.Store, quota Quota, bin *store.Bin, size int64) error {
+	if bin.Owner == "" || size <= bin.Size {
+		return nil
+	}
+
+	if quota.MaxBinSize > 0 && size > quota.MaxBinSize {
+		return &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "bins are limited to " + strconv.FormatInt(quota.MaxBinSize, 10) + " bytes",
+		}
+	}
+
+	if quota.MaxBytes == 0 {
+		return nil
+	}
+
+	usage, err := svc.GetUsage(ctx, bin.Owner)
+	if err != nil {
+		return err
+	}
+
+	if usage.Bytes+size-bin.Size > quota.MaxBytes {
+		return &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "bin exceeds the storage quota",
+		}
+	}
+
+	return nil
+}
+
+// writeQuotaError answers a failed quota check.
+func writeQuotaError(w http.ResponseWriter, r *http.Request, err error) {
+	var perr *policyError
+	if errors.As(err, &perr) {
+		writeJSONError(w, perr.status, perr.msg)
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "checking quota", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, "[quota] something went wrong")
+}
+
+// usageResponse is what the caller stores and may store.
+type usageResponse struct {
+	Usage *store.Usage `json:"usage"`
+	Quota struct {
+		MaxBins    int64 `json:"max_bins,omitempty"`
+		MaxBytes   int64 `json:"max_bytes,omitempty"`
+		MaxBinSize int64 `json:"max_bin_size,omitempty"`
+	} `json:"quota"`
+}
+
+// getUsage returns the usage and the quota of the caller.
+func getUsage(svc store.Store, quota Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usage, err := svc.GetUsage(r.Context(), currentUser(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading usage", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[usage] something went wrong")
+			return
+		}
+
+		resp := usageResponse{Usage: usage}
+		resp.Quota.MaxBins = quota.MaxBins
+		resp.Quota.MaxBytes = quota.MaxBytes
+		resp.Quota.MaxBinSize = quota.MaxBinSize
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pastebin/store"
+)
+
+// RateLimit lets each caller send Requests per Window, in bursts of up
+// to Requests. Zero Requests disable it.
+type RateLimit struct {
+	Requests int64
+	Window   time.Duration
+}
+
+// rateLimitKey names the bucket of the caller: their API token, their
+// account or their IP.
+func rateLimitKey(r *http.Request) string {
+	if id, ok := r.Context().Value(tokenIDKey).(string); ok {
+		return "token:" + id
+	}
+
+	if email := currentUser(r.Context()); email != "" {
+		return "user:" + email
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// seconds rounds d up to whole seconds, for headers.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// rateLimit refuses the requests of callers out of tokens. The buckets
+// are kept in the Store so replicas share them, and requests go through
+// when the Store fails.
+func rateLimit(svc store.Store, limit RateLimit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Requests == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state, err := svc.TakeRateToken(r.Context(), rateLimitKey(r), limit.Requests, limit.Window)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "taking rate limit token", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.FormatInt(state.Limit, 10))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatInt(state.Remaining, 10))
+			w.Header().Set("RateLimit-Reset", seconds(state.Reset))
+
+			if !state.Allowed {
+				w.Header().Set("Retry-After", seconds(state.RetryAfter))
+				writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry later")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
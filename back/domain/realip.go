@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// trustedProxies are the networks of the reverse proxies in front of the
+// API, whose X-Forwarded-For header tells the address of the client.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies reads addresses and CIDR ranges.
+func parseTrustedProxies(values []string) (trustedProxies, error) {
+	proxies := trustedProxies{}
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, errors.Errorf("invalid trusted proxy %q", value)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// client walks X-Forwarded-For back from the proxy that sent r, and
+// returns the first address that is not a trusted proxy. Entries written
+// by clients themselves come before it and are never reached.
+func (p trustedProxies) client(r *http.Request) (netip.Addr, bool) {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	addr := peer.Addr().Unmap()
+	if !p.contains(addr) {
+		return netip.Addr{}, false
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop.Unmap()
+		if !p.contains(addr) {
+			break
+		}
+	}
+
+	return addr, true
+}
+
+// realIP sets the RemoteAddr of the requests coming through trusted
+// proxies to the address of the client, which clientIP then reads for
+// login throttling, rate limits and analytics.
+func realIP(proxies trustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if addr, ok := proxies.client(r); ok {
+				r.RemoteAddr = net.JoinHostPort(addr.String(), "0")
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pastebin/mailer"
+	"pastebin/store"
+)
+
+// Default lifetimes of the mailed tokens.
+const (
+	defaultVerifyTokenTTL = 48 * time.Hour
+	defaultResetTokenTTL  = time.Hour
+)
+
+// mailTimeout bounds the sending of an email in the background.
+const mailTimeout = 30 * time.Second
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+type verifyRequest struct {
+	Token string `json:"token"`
+}
+
+type resetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// mailedToken is what the mails of one purpose say.
+type mailedToken struct {
+	subject string
+	intro   string
+	path    string
+	ttl     func(Options) time.Duration
+}
+
+var mailedTokens = map[string]mailedToken{
+	store.PurposeVerifyEmail: {
+		subject: "Verify your pastebin email",
+		intro:   "Open this link to verify the email of your pastebin account:",
+		path:    "/verify-email",
+		ttl:     func(opts Options) time.Duration { return opts.VerifyTokenTTL },
+	},
+	store.PurposeResetPassword: {
+		subject: "Reset your pastebin password",
+		intro:   "Open this link to choose a new password for your pastebin account:",
+		path:    "/reset-password",
+		ttl:     func(opts Options) time.Duration { return opts.ResetTokenTTL },
+	},
+}
+
+// shortDuration formats d without its zero minutes and seconds, such as
+// 48h rather than 48h0m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+// mailToken issues a token for purpose to email and mails its link. It
+// runs in the background, so that answers take the same time whether an
+// email is sent or not.
+func mailToken(ctx context.Context, svc store.Store, opts Options, purpose, email string) {
+	mail := mailedTokens[purpose]
+	ttl := mail.ttl(opts)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		token, err := svc.CreateOneTimeToken(ctx, purpose, email, ttl)
+		if err != nil {
+			slog.ErrorContext(ctx, "creating mailed token", "purpose", purpose, "err", err)
+			return
+		}
+
+		link := strings.TrimRight(opts.PublicURL, "/") + mail.path + "?token=" + url.QueryEscape(token)
+		err = opts.Mailer.Send(ctx, mailer.Message{
+			To:      email,
+			Subject: mail.subject,
+			Body: mail.intro + "\n\n" + link + "\n\nThe link expires in " + shortDuration(ttl) +
+				" and works once. If you did not ask for it, ignore this email.\n",
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "mailing token", "purpose", purpose, "err", err)
+		}
+	}()
+}
+
+// acceptedMail answers the requests mailing a link, the same way whether
+// the account exists or not.
+func acceptedMail(w http.ResponseWriter) {
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the account exists, an email is on its way",
+	})
+}
+
+// verifyEmail marks the email of a user verified with the token mailed
+// to them.
+func verifyEmail(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &verifyRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil || req.Token == "" {
+			writeJSONError(w, http.StatusBadRequest, "token is required")
+			return
+		}
+
+		email, err := svc.ConsumeOneTimeToken(r.Context(), store.PurposeVerifyEmail, req.Token)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "consuming verification token", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[verify email] something went wrong")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[verify email] something went wrong")
+			return
+		}
+
+		user.EmailVerified = true
+		updated, err := svc.UpdateUser(r.Context(), *user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "updating user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[verify email] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, updated.Public())
+	}
+}
+
+// resendVerification mails a new verification link to an unverified
+// user.
+func resendVerification(svc store.Store, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &emailRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid email")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[verify email] something went wrong")
+			return
+		}
+
+		if user != nil && !user.EmailVerified && !user.Disabled {
+			mailToken(r.Context(), svc, opts, store.PurposeVerifyEmail, email)
+		}
+
+		acceptedMail(w)
+	}
+}
+
+// forgotPassword mails a password reset link to a user.
+func forgotPassword(svc store.Store, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &emailRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "invalid email")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		if user != nil && !user.Disabled {
+			mailToken(r.Context(), svc, opts, store.PurposeResetPassword, email)
+		}
+
+		acceptedMail(w)
+	}
+}
+
+// resetPassword sets a new password with the token mailed to the user,
+// which also proves they own their email. Their sessions end and their
+// failed logins are forgotten.
+func resetPassword(svc store.Store, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &resetRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil || req.Token == "" {
+			writeJSONError(w, http.StatusBadRequest, "token is required")
+			return
+		}
+
+		// the password is checked before the token is consumed, so a
+		// refused password leaves the token usable for another attempt
+		email, err := svc.PeekOneTimeToken(r.Context(), store.PurposeResetPassword, req.Token)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "reading reset token", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		if msg := opts.PasswordPolicy.check(req.Password, email); msg != "" {
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return
+		}
+
+		// only one of concurrent resets consumes the token
+		_, err = svc.ConsumeOneTimeToken(r.Context(), store.PurposeResetPassword, req.Token)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "consuming reset token", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusBadRequest, "invalid or expired token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		user.Password = req.Password
+		user.EmailVerified = true
+		_, err = svc.UpdateUser(r.Context(), *user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "updating user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		err = svc.DeleteSessionsByOwner(r.Context(), email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "revoking sessions", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[reset password] something went wrong")
+			return
+		}
+
+		err = svc.ClearLoginFailures(r.Context(), "account:"+email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "clearing login failures", "err", err)
+		}
+
+		slog.InfoContext(r.Context(), "password reset", "user", email)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Error("encoding response", "err", err)
+	}
+}
+
+// writeJSONError answers with a {"error": msg} body.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"context"
+	"net/http"
+
+	"pastebin/store"
+)
+
+// permission is an action reserved to some roles.
+type permission int
+
+const (
+	permViewUsers permission = iota
+	permManageUsers
+	permDeleteAnyBin
+	permViewMetrics
+)
+
+// rolePermissions lists what each role may do beyond a plain user.
+var rolePermissions = map[string][]permission{
+	store.RoleModerator: {permViewUsers, permDeleteAnyBin},
+	store.RoleAdmin:     {permViewUsers, permManageUsers, permDeleteAnyBin, permViewMetrics},
+}
+
+// validRole reports whether role is a known role.
+func validRole(role string) bool {
+	switch role {
+	case store.RoleUser, store.RoleModerator, store.RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+// currentRole returns the role of the authenticated caller, or "".
+func currentRole(ctx context.Context) string {
+	role, _ := ctx.Value(userRoleKey).(string)
+	return role
+}
+
+// can reports whether the role of the caller grants perm.
+func can(ctx context.Context, perm permission) bool {
+	for _, p := range rolePermissions[currentRole(ctx)] {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requirePermission refuses callers whose role does not grant perm.
+func requirePermission(perm permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if currentUser(r.Context()) == "" {
+				writeJSONError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+
+			if !can(r.Context(), perm) {
+				writeJSONError(w, http.StatusForbidden, "insufficient role")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
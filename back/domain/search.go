@@ -0,0 +1,246 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/search"
+	"pastebin/store"
+)
+
+// maxIndexedBytes bounds how much of a text file is indexed.
+const maxIndexedBytes = 1 << 20
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type indexedStore struct {
+	store.Store
+	index search.Index
+	blobs blob.Storage
+}
+
+// NewIndexedStore keeps index up to date with the bins created, updated
+// and deleted through svc.
+func NewIndexedStore(svc store.Store, index search.Index, blobs blob.Storage) store.Store {
+	return &indexedStore{
+		Store: svc,
+		index: index,
+		blobs: blobs,
+	}
+}
+
+func (s *indexedStore) CreateBin(ctx context.Context, bin store.Bin) (*store.Bin, error) {
+	created, err := s.Store.CreateBin(ctx, bin)
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexBin(ctx, created)
+
+	return created, nil
+}
+
+func (s *indexedStore) UpdateBin(ctx context.Context, id string, patch store.BinPatch) (*store.Bin, error) {
+	updated, err := s.Store.UpdateBin(ctx, id, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexBin(ctx, updated)
+
+	return updated, nil
+}
+
+func (s *indexedStore) AddBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	updated, err := s.Store.AddBinTags(ctx, id, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexBin(ctx, updated)
+
+	return updated, nil
+}
+
+func (s *indexedStore) RemoveBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	updated, err := s.Store.RemoveBinTags(ctx, id, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexBin(ctx, updated)
+
+	return updated, nil
+}
+
+func (s *indexedStore) DeleteBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	deleted, err := s.Store.DeleteBinByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.index.Remove(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "removing bin from index", "bin", id, "err", err)
+	}
+
+	return deleted, nil
+}
+
+// Reindex adds every stored bin to index, filling an index that does not
+// outlive the server.
+func Reindex(ctx context.Context, svc store.Store, index search.Index, blobs blob.Storage) error {
+	bins, err := svc.GetAllBins(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range bins {
+		err = index.Add(ctx, binDocument(ctx, blobs, &bins[i]))
+		if err != nil {
+			return errors.Wrapf(err, "couldnt index bin %s", bins[i].ID)
+		}
+	}
+
+	slog.InfoContext(ctx, "indexed bins", "count", len(bins))
+
+	return nil
+}
+
+// indexBin never fails the write it follows, a bin missing from the
+// index is only missing from search results.
+func (s *indexedStore) indexBin(ctx context.Context, bin *store.Bin) {
+	err := s.index.Add(ctx, binDocument(ctx, s.blobs, bin))
+	if err != nil {
+		slog.ErrorContext(ctx, "indexing bin", "bin", bin.ID, "err", err)
+	}
+}
+
+// binDocument collects the searchable text of a bin: the content of text
+// bins and the beginning of the text files of file bins.
+func binDocument(ctx context.Context, blobs blob.Storage, bin *store.Bin) search.Document {
+	doc := search.Document{
+		ID: bin.ID,
+		Fields: map[string]string{
+			"alias": bin.Alias,
+			"title": bin.Title,
+			"tags":  strings.Join(bin.Tags, " "),
+		},
+	}
+
+	if isTextBin(bin) {
+		doc.Fields["content"] = bin.Contain
+		return doc
+	}
+
+	names := []string{}
+	content := []string{}
+	for _, file := range bin.Files {
+		names = append(names, file.Name)
+
+		if !isTextType(file.ContentType) {
+			continue
+		}
+
+		text, err := readText(ctx, blobs, file.Key)
+		if err != nil {
+			slog.WarnContext(ctx, "reading file to index", "bin", bin.ID, "file", file.Name, "err", err)
+			continue
+		}
+		content = append(content, text)
+	}
+
+	doc.Fields["files"] = strings.Join(names, " ")
+	doc.Fields["content"] = strings.Join(content, "\n")
+
+	return doc
+}
+
+func readText(ctx context.Context, blobs blob.Storage, key string) (string, error) {
+	f, err := blobs.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	text, err := io.ReadAll(io.LimitReader(f, maxIndexedBytes))
+	if err != nil {
+		return "", err
+	}
+
+	return string(text), nil
+}
+
+type searchResult struct {
+	store.PublicBin
+	Score float64 `json:"score"`
+}
+
+// searchBins returns the bins matching the q parameter, best match
+// first, among those the caller is allowed to list.
+func searchBins(svc store.Store, index search.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("q")
+		if strings.TrimSpace(query) == "" {
+			writeJSONError(w, http.StatusBadRequest, "missing q parameter")
+			return
+		}
+
+		limit := defaultSearchLimit
+		if val := r.URL.Query().Get("limit"); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = min(n, maxSearchLimit)
+		}
+
+		hits, err := index.Search(r.Context(), query)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "searching bins", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[search] something went wrong")
+			return
+		}
+
+		results := []searchResult{}
+		for _, hit := range hits {
+			if len(results) == limit {
+				break
+			}
+
+			bin, err := svc.GetBinByID(r.Context(), hit.ID)
+			if errors.Is(err, store.ErrNotFound) {
+				// the bin expired since it was indexed
+				index.Remove(r.Context(), hit.ID)
+				continue
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "loading bin", "err", err)
+				writeJSONError(w, http.StatusInternalServerError, "[search] something went wrong")
+				return
+			}
+
+			if isListed(r.Context(), bin) {
+				results = append(results, searchResult{PublicBin: bin.Public(), Score: hit.Score})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(results)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "encoding response", "err", err)
+		}
+	}
+}
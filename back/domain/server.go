@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serve runs server until ctx is cancelled. It then reports draining,
+// waits DrainDelay and lets the in-flight requests, uploads included,
+// finish within ShutdownTimeout.
+func serve(ctx context.Context, server *http.Server, opts Options) error {
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "address", server.Addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "couldnt serve")
+	case <-ctx.Done():
+	}
+
+	slog.Info("draining", "delay", opts.Server.DrainDelay)
+	opts.Health.Drain()
+	time.Sleep(opts.Server.DrainDelay)
+
+	shutdownCtx := context.Background()
+	if opts.Server.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, opts.Server.ShutdownTimeout)
+		defer cancel()
+	}
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		server.Close()
+		return errors.Wrap(err, "couldnt drain requests")
+	}
+
+	slog.Info("server stopped")
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package domain
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// Default lifetimes of the login tokens. Access tokens are short lived as
+// they are checked without the refresh token rotation.
+const (
+	defaultAccessTokenTTL = 15 * time.Minute
+	defaultSessionTTL     = 30 * 24 * time.Hour
+)
+
+// tokenResponse answers a login or a refresh. Token repeats AccessToken
+// for the clients of the first login API.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signAccessToken issues a JWT for session, valid for ttl.
+func signAccessToken(secretKey []byte, session *store.Session, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": session.Owner,
+		"sid":   session.ID,
+		"exp":   time.Now().Add(ttl).Unix(),
+	})
+
+	signed, err := token.SignedString(secretKey)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt sign access token")
+	}
+
+	return signed, nil
+}
+
+// writeTokens answers with an access token for session and its refresh
+// token.
+func writeTokens(w http.ResponseWriter, r *http.Request, opts Options, session *store.Session, refresh string) {
+	access, err := signAccessToken(opts.SecretKey, session, opts.AccessTokenTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "signing access token", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		Token:        access,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(opts.AccessTokenTTL / time.Second),
+	})
+}
+
+// startSession logs email in, opening a new session.
+func startSession(w http.ResponseWriter, r *http.Request, svc store.Store, opts Options, email string) {
+	session, refresh, err := svc.CreateSession(r.Context(), store.Session{
+		Owner:     email,
+		ExpiresAt: time.Now().Add(opts.SessionTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating session", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	writeTokens(w, r, opts, session, refresh)
+}
+
+// refreshSession exchanges a refresh token for a new access token and a
+// new refresh token. Reusing a refresh token revokes its session.
+func refreshSession(svc store.Store, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &refreshRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil || req.RefreshToken == "" {
+			writeJSONError(w, http.StatusBadRequest, "refresh_token is required")
+			return
+		}
+
+		session, refresh, err := svc.RotateSession(r.Context(), req.RefreshToken)
+		if errors.Is(err, store.ErrRefreshReused) {
+			slog.WarnContext(r.Context(), "refresh token reused, session revoked")
+			writeJSONError(w, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "refreshing session", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[session] something went wrong")
+			return
+		}
+
+		writeTokens(w, r, opts, session, refresh)
+	}
+}
+
+// logout revokes the session of the access token of the caller.
+func logout(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := currentSession(r.Context())
+		if id == "" {
+			writeJSONError(w, http.StatusBadRequest, "not authenticated with a login session")
+			return
+		}
+
+		err := svc.DeleteSession(r.Context(), currentUser(r.Context()), id)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "deleting session", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[logout] something went wrong")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// logoutAll revokes every session of the caller.
+func logoutAll(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := svc.DeleteSessionsByOwner(r.Context(), currentUser(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "deleting sessions", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[logout] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "logged out of every session")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getSessions lists the open sessions of the caller.
+func getSessions(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessions, err := svc.GetSessionsByOwner(r.Context(), currentUser(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing sessions", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[sessions] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, sessions)
+	}
+}
+
+// deleteSession revokes one session of the caller.
+func deleteSession(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := svc.DeleteSession(r.Context(), currentUser(r.Context()), chi.URLParam(r, "sessionID"))
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "deleting session", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[sessions] something went wrong")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,177 @@
+package domain
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+const maxTagLength = 64
+
+// normalizeTags lowercases tags and checks they only use letters, digits
+// and - _ . : such as "incident-4211" or "ci".
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+
+		if len(tag) > maxTagLength {
+			return nil, errors.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:", r) {
+				return nil, errors.Errorf("tag %q contains invalid character %q", tag, r)
+			}
+		}
+
+		normalized = append(normalized, tag)
+	}
+
+	return normalized, nil
+}
+
+// parseTags reads a comma separated list of tags.
+func parseTags(list string) ([]string, error) {
+	return normalizeTags(strings.Split(list, ","))
+}
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// loadEditableBin fetches the bin of the binID URL parameter and checks
+// the caller may change it.
+func loadEditableBin(w http.ResponseWriter, r *http.Request, svc store.Store) (*store.Bin, bool) {
+	bin, err := svc.GetBinByID(r.Context(), chi.URLParam(r, "binID"))
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !canView(r.Context(), bin)) {
+		writeJSONError(w, http.StatusNotFound, "bin not found")
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading bin", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[bin] something went wrong")
+		return nil, false
+	}
+
+	if !canEdit(r, bin) {
+		writeJSONError(w, http.StatusForbidden, "only the owner can change this bin")
+		return nil, false
+	}
+
+	return bin, true
+}
+
+func addBinTags(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := tagsRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		tags, err := normalizeTags(req.Tags)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		bin, ok := loadEditableBin(w, r, svc)
+		if !ok {
+			return
+		}
+
+		bin, err = svc.AddBinTags(r.Context(), bin.ID, tags)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "tagging bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[tags] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, bin.Public())
+	}
+}
+
+func removeBinTag(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bin, ok := loadEditableBin(w, r, svc)
+		if !ok {
+			return
+		}
+
+		tag := strings.ToLower(chi.URLParam(r, "tag"))
+		bin, err := svc.RemoveBinTags(r.Context(), bin.ID, []string{tag})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "untagging bin", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[tags] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, bin.Public())
+	}
+}
+
+// getTags lists every tag with its number of bins, most used first.
+func getTags(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts, err := svc.GetTagCounts(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "counting tags", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[tags] something went wrong")
+			return
+		}
+
+		tags := []tagCount{}
+		for tag, count := range counts {
+			tags = append(tags, tagCount{Tag: tag, Count: count})
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if tags[i].Count != tags[j].Count {
+				return tags[i].Count > tags[j].Count
+			}
+			return tags[i].Tag < tags[j].Tag
+		})
+
+		writeJSON(w, http.StatusOK, tags)
+	}
+}
+
+func getBinsByTag(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := strings.ToLower(chi.URLParam(r, "tag"))
+
+		all, err := svc.GetBinsByTag(r.Context(), tag)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing bins by tag", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[tags] something went wrong")
+			return
+		}
+
+		bins := []store.Bin{}
+		for _, bin := range all {
+			if isListed(r.Context(), &bin) {
+				bins = append(bins, bin)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, store.PublicBins(bins))
+	}
+}
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+const (
+	maxTokenNameLength = 100
+	maxTokensPerUser   = 50
+)
+
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a duration such as 720h, empty for a token that never
+	// expires.
+	ExpiresIn string `json:"expires_in"`
+}
+
+// createdToken is a new token along with its secret, shown only once.
+type createdToken struct {
+	*store.APIToken
+	Token string `json:"token"`
+}
+
+// validScope reports whether scope is a known scope.
+func validScope(scope string) bool {
+	switch scope {
+	case store.ScopeBinsRead, store.ScopeBinsWrite, store.ScopeAdmin:
+		return true
+	}
+
+	return false
+}
+
+// createToken issues an API token to the caller. A token can only be
+// issued scopes the caller has.
+func createToken(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &tokenRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > maxTokenNameLength {
+			writeJSONError(w, http.StatusBadRequest, "name is required and limited to 100 characters")
+			return
+		}
+
+		if len(req.Scopes) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "at least one scope is required")
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !validScope(scope) {
+				writeJSONError(w, http.StatusBadRequest, "scopes must be bins:read, bins:write or admin")
+				return
+			}
+			if !hasScope(r.Context(), scope) {
+				writeJSONError(w, http.StatusForbidden, "cannot grant the "+scope+" scope")
+				return
+			}
+		}
+
+		token := store.APIToken{
+			Owner:  currentUser(r.Context()),
+			Name:   req.Name,
+			Scopes: req.Scopes,
+		}
+
+		if req.ExpiresIn != "" {
+			d, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || d <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "expires_in must be a positive duration such as 720h")
+				return
+			}
+			token.ExpiresAt = time.Now().Add(d)
+		}
+
+		existing, err := svc.GetAPITokensByOwner(r.Context(), token.Owner)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing api tokens", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[create token] something went wrong")
+			return
+		}
+		if len(existing) >= maxTokensPerUser {
+			writeJSONError(w, http.StatusConflict, "too many tokens, revoke some first")
+			return
+		}
+
+		created, secret, err := svc.CreateAPIToken(r.Context(), token)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "creating api token", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[create token] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "api token created", "token", created.ID, "scopes", created.Scopes)
+		writeJSON(w, http.StatusCreated, createdToken{APIToken: created, Token: secret})
+	}
+}
+
+// getTokens lists the tokens of the caller, without their secrets.
+func getTokens(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := svc.GetAPITokensByOwner(r.Context(), currentUser(r.Context()))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "listing api tokens", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[tokens] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// deleteToken revokes a token of the caller.
+func deleteToken(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "tokenID")
+
+		err := svc.DeleteAPIToken(r.Context(), currentUser(r.Context()), id)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "token not found")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "deleting api token", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[delete token] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "api token revoked", "token", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,503 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/store"
+)
+
+// Resumable uploads follow the tus protocol, see https://tus.io/protocols/resumable-upload
+// The request that completes an upload answers with the bin it created.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+// uploadBlobKey is where the data of an upload session is appended.
+func uploadBlobKey(id string) string {
+	return "uploads/" + id
+}
+
+// uploadLockTTL bounds how long an upload stays locked by a server that
+// stopped without unlocking it. The lock is renewed while it is held.
+const uploadLockTTL = 30 * time.Second
+
+// errUploadLocked is returned when another request appends to an upload.
+var errUploadLocked = errors.New("upload is already in progress")
+
+// lockUpload guards an upload session against concurrent requests, on
+// every instance of the API, until the returned unlock is called.
+func lockUpload(ctx context.Context, svc store.Store, id string) (func(), error) {
+	token := uuid.NewString()
+
+	locked, err := svc.LockUpload(ctx, id, token, uploadLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, errUploadLocked
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(uploadLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := svc.LockUpload(context.WithoutCancel(ctx), id, token, uploadLockTTL)
+				if err != nil {
+					slog.ErrorContext(ctx, "renewing upload lock", "upload", id, "err", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+
+		err := svc.UnlockUpload(context.WithoutCancel(ctx), id, token)
+		if err != nil {
+			slog.ErrorContext(ctx, "unlocking upload", "upload", id, "err", err)
+		}
+	}, nil
+}
+
+// loadUpload returns the upload session of the request. Uploads started
+// by a user are hidden from everybody else.
+func loadUpload(w http.ResponseWriter, r *http.Request, svc store.Store) (*store.Upload, bool) {
+	upload, err := svc.GetUpload(r.Context(), chi.URLParam(r, "uploadID"))
+	if err != nil {
+		writeUploadError(w, r, err)
+		return nil, false
+	}
+
+	if upload.Owner != "" && upload.Owner != currentUser(r.Context()) {
+		writeUploadError(w, r, store.ErrNotFound)
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// requireTus rejects requests speaking another version of the protocol.
+func requireTus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			writeJSONError(w, http.StatusPreconditionFailed, "unsupported tus version")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header, a comma
+// separated list of keys followed by base64 encoded values.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid metadata value for %s", key)
+		}
+
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+func formatUploadMetadata(metadata map[string]string) string {
+	pairs := []string{}
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *store.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// writeUploadError maps store errors to the status codes expected by
+// tus clients.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+
+	if errors.Is(err, errUploadLocked) {
+		writeJSONError(w, http.StatusLocked, err.Error())
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "loading upload", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, "[upload] something went wrong")
+}
+
+// checkUpload applies the upload policy to the assembled data of an
+// upload, which is only known once every chunk is received.
+func checkUpload(ctx context.Context, blobs blob.Storage, policy UploadPolicy, filename string, upload *store.Upload) (string, error) {
+	f, err := blobs.Open(ctx, uploadBlobKey(upload.ID))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	contentType, err := detectContentType(filename, head[:n])
+	if err != nil {
+		return "", err
+	}
+
+	err = policy.check(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	limit := policy.maxSize(contentType)
+	if limit > 0 && upload.Length > limit {
+		return "", &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    contentType + " uploads are limited to " + strconv.FormatInt(limit, 10) + " bytes",
+		}
+	}
+
+	return contentType, nil
+}
+
+// finishUpload turns a completed upload session into a bin. The session
+// and its data are dropped when the bin is refused.
+func finishUpload(ctx context.Context, svc store.Store, blobs blob.Storage, policy UploadPolicy, upload *store.Upload) (*store.Bin, error) {
+	key := uploadBlobKey(upload.ID)
+	filename := cleanFilename(upload.Metadata["filename"])
+
+	contentType, err := checkUpload(ctx, blobs, policy, filename, upload)
+	if err != nil {
+		discardUpload(ctx, svc, blobs, upload.ID)
+		return nil, err
+	}
+
+	// checked when the upload was created
+	tags, _ := parseTags(upload.Metadata["tags"])
+
+	bin := store.Bin{
+		Alias:      upload.Metadata["alias"],
+		Title:      upload.Metadata["title"],
+		Visibility: upload.Metadata["visibility"],
+		Owner:      upload.Owner,
+		Tags:       tags,
+		Filename:   filename,
+		Contain:    key,
+		Files: []store.BinFile{{
+			Name:        filename,
+			Key:         key,
+			Size:        upload.Length,
+			ContentType: contentType,
+		}},
+	}
+	if bin.Alias == "" {
+		bin.Alias = newAlias()
+	}
+	describeFiles(&bin)
+
+	err = checkAlias(bin.Alias)
+	if err != nil {
+		discardUpload(ctx, svc, blobs, upload.ID)
+		return nil, err
+	}
+
+	created, err := svc.CreateBin(ctx, bin)
+	if err != nil {
+		discardUpload(ctx, svc, blobs, upload.ID)
+		return nil, err
+	}
+
+	// the bin exists, a leftover session only lingers until it expires
+	err = svc.DeleteUpload(ctx, upload.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "deleting finished upload", "upload", upload.ID, "err", err)
+	}
+
+	return created, nil
+}
+
+// discardUpload drops an upload session along with its data.
+func discardUpload(ctx context.Context, svc store.Store, blobs blob.Storage, id string) {
+	blobs.Delete(ctx, uploadBlobKey(id))
+	svc.DeleteUpload(ctx, id)
+}
+
+// writeFinishError answers an upload whose bin could not be created.
+func writeFinishError(w http.ResponseWriter, r *http.Request, err error) {
+	var perr *policyError
+	if errors.As(err, &perr) {
+		writeJSONError(w, perr.status, perr.msg)
+		return
+	}
+
+	if errors.Is(err, store.ErrAliasTaken) {
+		writeJSONError(w, http.StatusConflict, "alias already taken")
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "saving bin to database", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, "[save bin] something went wrong")
+}
+
+func optionsUpload(opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(opts.MaxUploadSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func createUpload(svc store.Store, blobs blob.Storage, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid Upload-Length")
+			return
+		}
+
+		if length > opts.MaxUploadSize {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "upload too large")
+			return
+		}
+
+		err = checkQuota(r.Context(), svc, opts.Quota, length)
+		if err != nil {
+			writeQuotaError(w, r, err)
+			return
+		}
+
+		metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid Upload-Metadata")
+			return
+		}
+
+		_, err = parseTags(metadata["tags"])
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if metadata["alias"] != "" {
+			err = checkAlias(metadata["alias"])
+			if err != nil {
+				writeFinishError(w, r, err)
+				return
+			}
+		}
+
+		err = checkVisibility(&store.Bin{
+			Visibility: metadata["visibility"],
+			Owner:      currentUser(r.Context()),
+		})
+		if err != nil {
+			writeFinishError(w, r, err)
+			return
+		}
+
+		upload, err := svc.CreateUpload(r.Context(), store.Upload{
+			Length:    length,
+			Metadata:  metadata,
+			Owner:     currentUser(r.Context()),
+			ExpiresAt: time.Now().Add(opts.UploadExpiry),
+		})
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		_, err = blobs.Append(r.Context(), uploadBlobKey(upload.ID), strings.NewReader(""))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "creating upload blob", "err", err)
+			svc.DeleteUpload(r.Context(), upload.ID)
+			writeJSONError(w, http.StatusInternalServerError, "[upload] something went wrong")
+			return
+		}
+
+		w.Header().Set("Location", "/uploads/"+upload.ID)
+		setUploadHeaders(w, upload)
+
+		if length == 0 {
+			bin, err := finishUpload(r.Context(), svc, blobs, opts.Policy, upload)
+			if err != nil {
+				writeFinishError(w, r, err)
+				return
+			}
+
+			writeJSON(w, http.StatusCreated, bin.Public())
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func headUpload(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upload, ok := loadUpload(w, r, svc)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if len(upload.Metadata) != 0 {
+			w.Header().Set("Upload-Metadata", formatUploadMetadata(upload.Metadata))
+		}
+		setUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func patchUpload(svc store.Store, blobs blob.Storage, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid Upload-Offset")
+			return
+		}
+
+		upload, ok := loadUpload(w, r, svc)
+		if !ok {
+			return
+		}
+
+		id := upload.ID
+		unlock, err := lockUpload(r.Context(), svc, id)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		defer unlock()
+
+		// read again, a request holding the lock may have appended
+		upload, err = svc.GetUpload(r.Context(), id)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		if offset != upload.Offset {
+			writeJSONError(w, http.StatusConflict, "Upload-Offset does not match")
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, upload.Length-upload.Offset)
+		n, copyErr := blobs.Append(r.Context(), uploadBlobKey(id), body)
+
+		// keep whatever was received, the client resumes from there
+		upload.Offset += n
+		upload.ExpiresAt = time.Now().Add(opts.UploadExpiry)
+		upload, err = svc.UpdateUpload(r.Context(), *upload)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		if upload.Offset == upload.Length {
+			bin, err := finishUpload(r.Context(), svc, blobs, opts.Policy, upload)
+			if err != nil {
+				writeFinishError(w, r, err)
+				return
+			}
+
+			// the last chunk answers with the bin it created
+			setUploadHeaders(w, upload)
+			writeJSON(w, http.StatusOK, bin.Public())
+			return
+		}
+
+		if copyErr != nil {
+			slog.ErrorContext(r.Context(), "appending to upload", "upload", upload.ID, "err", copyErr)
+			setUploadHeaders(w, upload)
+			if isTooLarge(copyErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "chunk exceeds Upload-Length")
+				return
+			}
+			writeJSONError(w, http.StatusInternalServerError, "[upload] something went wrong")
+			return
+		}
+
+		setUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func terminateUpload(svc store.Store, blobs blob.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upload, ok := loadUpload(w, r, svc)
+		if !ok {
+			return
+		}
+
+		id := upload.ID
+		unlock, err := lockUpload(r.Context(), svc, id)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		defer unlock()
+
+		err = blobs.Delete(r.Context(), uploadBlobKey(id))
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		err = svc.DeleteUpload(r.Context(), id)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,439 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+	"pastebin/totp"
+)
+
+// loginChallengeTTL is how long the second step of a login may wait for
+// the one-time code.
+const loginChallengeTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes a user is given, each
+// usable once in place of a one-time code.
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+type challengeRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// challengeResponse answers the first step of a login when the user has
+// two-factor authentication.
+type challengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+type enrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// normalizeRecoveryCode drops the case, spaces and dashes users may type.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns fresh recovery codes, such as abcde-fghij, and
+// their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "couldnt generate recovery code")
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b)[:10])
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// checkSecondFactor accepts a one-time code of user, never the same twice,
+// or one of their recovery codes, which is then used up. Both are used
+// up in the store, so concurrent requests cannot share a code. It fails
+// when the secret of the user cannot be opened and no recovery code is
+// given.
+func checkSecondFactor(ctx context.Context, svc store.Store, user *store.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return svc.UseTOTPStep(ctx, user.Email, step)
+	}
+
+	left, err := svc.UseRecoveryCode(ctx, user.Email, hashRecoveryCode(code))
+	if err == nil {
+		slog.InfoContext(ctx, "recovery code used", "user", user.Email, "left", left)
+		return true, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+
+	if user.TOTPSecret == "" {
+		return false, user.TOTPError()
+	}
+
+	return false, nil
+}
+
+// loadCurrentUser returns the authenticated caller.
+func loadCurrentUser(w http.ResponseWriter, r *http.Request, svc store.Store) *store.User {
+	user, err := svc.GetUserByEmail(r.Context(), currentUser(r.Context()))
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return nil
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "loading user", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[auth] something went wrong")
+		return nil
+	}
+
+	return user
+}
+
+// writeSecondFactorError answers a code checked by checkSecondFactor.
+func writeSecondFactorError(w http.ResponseWriter, r *http.Request, ok bool, err error) bool {
+	if err != nil {
+		slog.ErrorContext(r.Context(), "checking second factor", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+		return true
+	}
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "invalid code")
+		return true
+	}
+
+	return false
+}
+
+// enrollTwoFactor gives the caller a new TOTP secret to add to their
+// authenticator app. It is only enabled by verifyTwoFactor.
+func enrollTwoFactor(svc store.Store, opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := loadCurrentUser(w, r, svc)
+		if user == nil {
+			return
+		}
+
+		if user.TOTPEnabled {
+			writeJSONError(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "generating totp secret", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		user.TOTPSecret = secret
+		_, err = svc.UpdateUser(r.Context(), *user)
+		if errors.Is(err, store.ErrNoSecretsKey) {
+			writeJSONError(w, http.StatusServiceUnavailable, "two-factor authentication is not configured")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "saving totp secret", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, enrollResponse{
+			Secret: secret,
+			URI:    totp.URI(opts.TOTPIssuer, user.Email, secret),
+		})
+	}
+}
+
+// verifyTwoFactor enables two-factor authentication once the caller sent
+// a code of their new secret, answering their recovery codes.
+func verifyTwoFactor(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &codeRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user := loadCurrentUser(w, r, svc)
+		if user == nil {
+			return
+		}
+
+		if user.TOTPEnabled {
+			writeJSONError(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+		if user.TOTPSecret == "" {
+			writeJSONError(w, http.StatusBadRequest, "two-factor authentication is not enrolled")
+			return
+		}
+
+		step, ok := totp.Validate(user.TOTPSecret, strings.TrimSpace(req.Code), time.Now())
+		if ok {
+			ok, err = svc.UseTOTPStep(r.Context(), user.Email, step)
+		}
+		if writeSecondFactorError(w, r, ok, err) {
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "generating recovery codes", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		err = svc.SetRecoveryCodes(r.Context(), user.Email, hashes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "saving recovery codes", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		user.TOTPEnabled = true
+		_, err = svc.UpdateUser(r.Context(), *user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "enabling two-factor", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "two-factor enabled", "user", user.Email)
+		writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// disableTwoFactor turns two-factor authentication off, given a one-time
+// or a recovery code.
+func disableTwoFactor(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &codeRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user := loadCurrentUser(w, r, svc)
+		if user == nil {
+			return
+		}
+
+		if !user.TOTPEnabled {
+			writeJSONError(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+			return
+		}
+
+		ok, err := checkSecondFactor(r.Context(), svc, user, req.Code)
+		if writeSecondFactorError(w, r, ok, err) {
+			return
+		}
+
+		err = resetTwoFactor(r.Context(), svc, user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "removing recovery codes", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		updated, err := svc.UpdateUser(r.Context(), *user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "disabling two-factor", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "two-factor disabled", "user", user.Email)
+		writeJSON(w, http.StatusOK, updated.Public())
+	}
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the caller,
+// given a one-time or a recovery code.
+func regenerateRecoveryCodes(svc store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &codeRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+
+		user := loadCurrentUser(w, r, svc)
+		if user == nil {
+			return
+		}
+
+		if !user.TOTPEnabled {
+			writeJSONError(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+			return
+		}
+
+		ok, err := checkSecondFactor(r.Context(), svc, user, req.Code)
+		if writeSecondFactorError(w, r, ok, err) {
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "generating recovery codes", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		err = svc.SetRecoveryCodes(r.Context(), user.Email, hashes)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "saving recovery codes", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[two-factor] something went wrong")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// resetTwoFactor removes the second factor of user, its recovery codes
+// at once and the rest once user is saved.
+func resetTwoFactor(ctx context.Context, svc store.Store, user *store.User) error {
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+
+	return svc.SetRecoveryCodes(ctx, user.Email, nil)
+}
+
+// writeLoginChallenge answers the first step of the login of a user with
+// two-factor authentication, whose password was right.
+func writeLoginChallenge(w http.ResponseWriter, r *http.Request, svc store.Store, email string) {
+	challenge, err := svc.CreateOneTimeToken(r.Context(), store.PurposeLoginChallenge, email, loginChallengeTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating login challenge", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, challengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int64(loginChallengeTTL / time.Second),
+	})
+}
+
+// loginSecondFactor opens a session for the user a login challenge was
+// issued to, given their one-time or a recovery code. A challenge can be
+// tried once, a wrong code takes logging in again and counts as a failed
+// login.
+func loginSecondFactor(svc store.Store, opts Options) http.HandlerFunc {
+	protection := opts.LoginProtection
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &challengeRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil || req.ChallengeToken == "" {
+			writeJSONError(w, http.StatusBadRequest, "challenge_token is required")
+			return
+		}
+
+		email, err := svc.ConsumeOneTimeToken(r.Context(), store.PurposeLoginChallenge, req.ChallengeToken)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid or expired challenge")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "consuming login challenge", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+			return
+		}
+
+		user, err := svc.GetUserByEmail(r.Context(), email)
+		if errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid or expired challenge")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "loading user", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+			return
+		}
+
+		if user.Disabled {
+			writeJSONError(w, http.StatusForbidden, "account disabled")
+			return
+		}
+
+		attempt := reserveLoginAttempt(w, r, svc, protection, email)
+		if attempt == nil {
+			return
+		}
+
+		failed := false
+		defer func() {
+			if !failed {
+				attempt.refund(r.Context(), svc)
+			}
+		}()
+
+		ok, err := checkSecondFactor(r.Context(), svc, user, req.Code)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "checking second factor", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, "[login] something went wrong")
+			return
+		}
+		if !ok {
+			failed = true
+			attempt.warnLockout(r.Context(), protection)
+			writeJSONError(w, http.StatusUnauthorized, "invalid code")
+			return
+		}
+
+		err = svc.ClearLoginFailures(r.Context(), attempt.accountSubject)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "clearing login failures", "err", err)
+		}
+
+		startSession(w, r, svc, opts, user.Email)
+	}
+}
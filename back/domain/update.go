@@ -2,19 +2,15 @@ package domain
 
 import (
 	"context"
-	"time"
 
 	"pastebin/store"
 )
 
-func UpdateBinByID(svc store.Store) func(context.Context, string, string, string) error {
-	return func(ctx context.Context, id string, contain string, alias string) error {
-		bin, err := svc.UpdateBin(ctx, store.Bin{
-			ID: id,
-			Alias: alias,
-			Contain: contain,
-			UpdatedAt: time.Now(),
-		})
+// UpdateBinByID applies patch to the bin with the given ID, keeping
+// everything it leaves out.
+func UpdateBinByID(svc Bins) func(context.Context, string, store.BinPatch) error {
+	return func(ctx context.Context, id string, patch store.BinPatch) error {
+		bin, err := svc.UpdateBin(ctx, id, patch)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,389 @@
+package domain
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+	"pastebin/store"
+)
+
+// maxFieldSize bounds the plain (non file) form fields of an upload.
+const maxFieldSize = 1 << 10
+
+// isTooLarge reports whether err comes from an http.MaxBytesReader
+// whose limit was exceeded.
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// blobKeyFor builds a fresh storage key keeping the extension of the
+// uploaded file name.
+func blobKeyFor(filename string) string {
+	return uuid.NewString() + strings.ToLower(filepath.Ext(filename))
+}
+
+// cleanFilename keeps only the base name of a client supplied file name.
+func cleanFilename(name string) string {
+	name = filepath.Base(filepath.FromSlash(name))
+	if name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+
+	return name
+}
+
+// extensionFor guesses a file extension from a content type, used when
+// a raw upload does not carry a file name.
+func extensionFor(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+
+	return exts[0]
+}
+
+// readField reads a small multipart form value.
+func readField(r io.Reader) (string, error) {
+	val, err := io.ReadAll(io.LimitReader(r, maxFieldSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(val) > maxFieldSize {
+		return "", errors.New("form field too long")
+	}
+
+	return string(val), nil
+}
+
+// readTextField reads the content of a text bin, checked against the
+// policy like a text/plain file.
+func readTextField(policy UploadPolicy, r io.Reader) (string, error) {
+	const contentType = "text/plain"
+
+	err := policy.check(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	var src io.Reader = r
+	limit := policy.maxSize(contentType)
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+
+	text, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+
+	if limit > 0 && int64(len(text)) > limit {
+		return "", &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    contentType + " uploads are limited to " + strconv.FormatInt(limit, 10) + " bytes",
+		}
+	}
+
+	return string(text), nil
+}
+
+// maxLanguageLength bounds the language name of a bin.
+const maxLanguageLength = 32
+
+// setBinOption sets an optional form field of a new bin: Expiry, a
+// duration such as 1h, BurnAfterRead, Password or Language.
+func setBinOption(bin *store.Bin, name, value string) error {
+	switch name {
+	case "Expiry":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return &policyError{status: http.StatusBadRequest, msg: "expiry must be a positive duration such as 30m or 24h"}
+		}
+		bin.ExpiresAt = time.Now().Add(d)
+	case "BurnAfterRead":
+		burn, err := strconv.ParseBool(value)
+		if err != nil {
+			return &policyError{status: http.StatusBadRequest, msg: "burn after read must be true or false"}
+		}
+		bin.BurnAfterRead = burn
+	case "Password":
+		bin.Password = value
+	case "Language":
+		if !validLanguage(value) {
+			return &policyError{status: http.StatusBadRequest, msg: "language must be a short name such as go or c++"}
+		}
+		bin.Language = strings.ToLower(value)
+	}
+
+	return nil
+}
+
+// validLanguage accepts names such as go, c++, c# or objective-c.
+func validLanguage(lang string) bool {
+	if lang == "" || len(lang) > maxLanguageLength {
+		return false
+	}
+
+	for _, r := range lang {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+#-._", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maxAliasLength is the longest alias a client may choose.
+const maxAliasLength = 64
+
+// validAlias accepts aliases of letters, digits, - and _, which are safe
+// in URLs and in the key patterns bins are looked up by.
+func validAlias(alias string) bool {
+	if alias == "" || len(alias) > maxAliasLength {
+		return false
+	}
+
+	for _, r := range alias {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reservedAliases are the static routes under /bins/, which would shadow
+// the bins of the same alias.
+var reservedAliases = map[string]bool{
+	"statistics": true,
+	"file":       true,
+}
+
+// checkAlias refuses the aliases validAlias does not accept and the
+// reserved ones.
+func checkAlias(alias string) error {
+	if !validAlias(alias) {
+		return &policyError{status: http.StatusBadRequest, msg: "alias must be 1 to 64 letters, digits, - or _"}
+	}
+
+	if reservedAliases[strings.ToLower(alias)] {
+		return &policyError{status: http.StatusBadRequest, msg: "alias " + alias + " is reserved"}
+	}
+
+	return nil
+}
+
+// aliasAlphabet makes aliases easy to type and to put in URLs.
+const aliasAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// newAlias generates the alias of a bin uploaded without one.
+func newAlias() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	for i := range b {
+		b[i] = aliasAlphabet[int(b[i])%len(aliasAlphabet)]
+	}
+
+	return string(b)
+}
+
+// checkVisibility refuses unknown visibilities, and private bins nobody
+// could read back.
+func checkVisibility(bin *store.Bin) error {
+	if !validVisibility(bin.Visibility) {
+		return &policyError{
+			status: http.StatusBadRequest,
+			msg:    "visibility must be public, unlisted or private",
+		}
+	}
+
+	if bin.Visibility == store.VisibilityPrivate && bin.Owner == "" {
+		return &policyError{
+			status: http.StatusUnauthorized,
+			msg:    "private bins require authentication",
+		}
+	}
+
+	return nil
+}
+
+// checkPatch validates the changes patch makes to bin, normalizing its
+// tags and language.
+func checkPatch(policy UploadPolicy, bin *store.Bin, patch *store.BinPatch) error {
+	if patch.Alias != nil {
+		err := checkAlias(*patch.Alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	if patch.Visibility != nil {
+		err := checkVisibility(&store.Bin{Owner: bin.Owner, Visibility: *patch.Visibility})
+		if err != nil {
+			return err
+		}
+	}
+
+	if patch.Tags != nil {
+		tags, err := normalizeTags(*patch.Tags)
+		if err != nil {
+			return &policyError{status: http.StatusBadRequest, msg: err.Error()}
+		}
+		patch.Tags = &tags
+	}
+
+	if patch.Language != nil && *patch.Language != "" {
+		if !validLanguage(*patch.Language) {
+			return &policyError{status: http.StatusBadRequest, msg: "language must be a short name such as go or c++"}
+		}
+		lang := strings.ToLower(*patch.Language)
+		patch.Language = &lang
+	}
+
+	if patch.Contain != nil {
+		if !isTextBin(bin) {
+			return &policyError{status: http.StatusBadRequest, msg: store.ErrNotTextBin.Error()}
+		}
+		_, err := readTextField(policy, strings.NewReader(*patch.Contain))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveFile streams r into blob storage under a new key, once its
+// content type is detected and accepted by the policy. The name must
+// not already be used by one of the files of the bin.
+func saveFile(ctx context.Context, blobs blob.Storage, policy UploadPolicy, files []store.BinFile, filename string, r io.Reader) (*store.BinFile, error) {
+	key := blobKeyFor(filename)
+
+	name := cleanFilename(filename)
+	if name == "" {
+		name = key
+	}
+
+	for _, file := range files {
+		if file.Name == name {
+			return nil, errors.Errorf("duplicate file name %q", name)
+		}
+	}
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	contentType, err := detectContentType(name, head)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.check(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var src io.Reader = br
+	limit := policy.maxSize(contentType)
+	if limit > 0 {
+		src = io.LimitReader(br, limit+1)
+	}
+
+	n, err := blobs.Put(ctx, key, src)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && n > limit {
+		blobs.Delete(ctx, key)
+		return nil, &policyError{
+			status: http.StatusRequestEntityTooLarge,
+			msg:    contentType + " uploads are limited to " + strconv.FormatInt(limit, 10) + " bytes",
+		}
+	}
+
+	return &store.BinFile{
+		Name:        name,
+		Key:         key,
+		Size:        n,
+		ContentType: contentType,
+	}, nil
+}
+
+// writeUploadFailure answers a failed upload with the status matching
+// err, or 400 with fallback.
+func writeUploadFailure(w http.ResponseWriter, err error, fallback string) {
+	var perr *policyError
+	switch {
+	case errors.As(err, &perr):
+		writeJSONError(w, perr.status, perr.msg)
+	case isTooLarge(err):
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "upload too large")
+	default:
+		writeJSONError(w, http.StatusBadRequest, fallback)
+	}
+}
+
+// describeFiles sets the content type and total size of a bin from its
+// files.
+func describeFiles(bin *store.Bin) {
+	bin.Size = 0
+	for _, file := range bin.Files {
+		bin.Size += file.Size
+	}
+
+	if len(bin.Files) == 1 {
+		bin.ContentType = bin.Files[0].ContentType
+	} else if len(bin.Files) > 1 {
+		bin.ContentType = "multipart/mixed"
+	}
+}
+
+// deleteFiles removes the blobs of files, used to roll back an upload.
+func deleteFiles(ctx context.Context, blobs blob.Storage, files []store.BinFile) {
+	for _, file := range files {
+		err := blobs.Delete(ctx, file.Key)
+		if err != nil {
+			slog.ErrorContext(ctx, "deleting blob", "key", file.Key, "err", err)
+		}
+	}
+}
+
+// deleteBin deletes the bin with the given ID along with its files.
+func deleteBin(ctx context.Context, svc store.Store, blobs blob.Storage, id string) (*store.Bin, error) {
+	bin, err := svc.DeleteBinByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTextBin(bin) {
+		deleteFiles(ctx, blobs, binFiles(bin))
+		deleteThumbnails(ctx, blobs, binFiles(bin))
+	}
+
+	return bin, nil
+}
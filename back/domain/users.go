@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"encoding/json"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// credentials is the body of the sign up and login requests. The
+// password was first sent as mot_de_passe, which is still accepted.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (c *credentials) UnmarshalJSON(data []byte) error {
+	type plain credentials
+	var v struct {
+		plain
+		MotDePasse string `json:"mot_de_passe"`
+	}
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	*c = credentials(v.plain)
+	if c.Password == "" {
+		c.Password = v.MotDePasse
+	}
+
+	return nil
+}
+
+// maxEmailLength is the longest address allowed by RFC 5321.
+const maxEmailLength = 254
+
+// normalizeEmail returns email trimmed and lowercased, reporting false
+// when it is not a bare address such as jane@example.com.
+func normalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || len(email) > maxEmailLength {
+		return "", false
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", false
+	}
+
+	return email, true
+}
+
+// maxPasswordLength is the longest password bcrypt hashes.
+const maxPasswordLength = 72
+
+// PasswordPolicy is the strength required from new passwords. MinClasses
+// is the number of character classes, out of lowercase and uppercase
+// letters, digits and symbols, a password must mix.
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int
+}
+
+// check returns why password is refused for the user with the given
+// email, or "".
+func (p PasswordPolicy) check(password, email string) string {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return "password must be at least " + strconv.Itoa(p.MinLength) + " characters long"
+	}
+
+	if len(password) > maxPasswordLength {
+		return "password must be at most " + strconv.Itoa(maxPasswordLength) + " bytes long"
+	}
+
+	if passwordClasses(password) < p.MinClasses {
+		return "password must mix at least " + strconv.Itoa(p.MinClasses) + " of lowercase letters, uppercase letters, digits and symbols"
+	}
+
+	if strings.EqualFold(password, email) {
+		return "password must not be the email"
+	}
+
+	return ""
+}
+
+// passwordClasses counts the character classes found in password.
+func passwordClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
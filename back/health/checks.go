@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"pastebin/blob"
+)
+
+// BlobWritable checks a probe blob can be written and removed.
+func BlobWritable(blobs blob.Storage) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		key := "healthcheck-" + uuid.NewString()
+
+		_, err := blobs.Put(ctx, key, strings.NewReader("ok"))
+		if err != nil {
+			return errors.Wrap(err, "couldnt write blob")
+		}
+
+		err = blobs.Delete(ctx, key)
+		if err != nil {
+			return errors.Wrap(err, "couldnt delete blob")
+		}
+
+		return nil
+	}
+}
+
+// FreeDisk checks the file system holding path has at least min bytes
+// available.
+func FreeDisk(path string, min uint64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		free, err := freeSpace(path)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt read free space of %s", path)
+		}
+
+		if free < min {
+			return errors.Errorf("%d bytes free, below the %d bytes minimum", free, min)
+		}
+
+		return nil
+	}
+}
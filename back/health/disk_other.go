@@ -0,0 +1,9 @@
+//go:build !unix
+
+package health
+
+// freeSpace is not measured on this platform, the disk is reported as
+// never full.
+func freeSpace(path string) (uint64, error) {
+	return ^uint64(0), nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package health
+
+import "syscall"
+
+func freeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
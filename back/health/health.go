@@ -0,0 +1,125 @@
+// Package health answers the liveness and readiness probes of the
+// service.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds each readiness check.
+const checkTimeout = 2 * time.Second
+
+// Check is a dependency the service needs to serve requests.
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Status is the outcome of one check.
+type Status struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// Report is the body of the probes.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Status `json:"checks,omitempty"`
+}
+
+// Checker runs the readiness checks. Once draining it reports the
+// service as unavailable so load balancers stop sending traffic.
+type Checker struct {
+	checks   []Check
+	draining atomic.Bool
+}
+
+func New(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Drain marks the service as shutting down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Run runs every check concurrently.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status: "ok",
+		Checks: make(map[string]Status, len(c.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Func(ctx)
+			status := Status{
+				Status:   "ok",
+				Duration: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status = "unavailable"
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[check.Name] = status
+			if err != nil {
+				report.Status = "unavailable"
+			}
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		report.Status = "draining"
+	}
+
+	return report
+}
+
+// Liveness answers as long as the process serves HTTP.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, r, http.StatusOK, Report{Status: "ok"})
+}
+
+// Readiness answers 200 when every check passes, 503 otherwise or while
+// draining.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeReport(w, r, status, report)
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "encoding health report", "err", err)
+	}
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer writes each email as a .eml file in dir instead of
+// sending it, for local testing.
+func NewFileMailer(dir, from string) (Mailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt create mail directory %s", dir)
+	}
+
+	return &fileMailer{dir: dir, from: from}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	if !headerSafe(msg.To) || !headerSafe(msg.Subject) {
+		return errors.Errorf("invalid email headers for %q", msg.To)
+	}
+
+	name := time.Now().UTC().Format("20060102T150405") + "-" + uuid.NewString() + ".eml"
+	err := os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt write email to %s", msg.To)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package mailer sends the emails of the service, such as the links
+// verifying addresses and resetting passwords.
+package mailer
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders msg from from as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// headerSafe reports whether s may go in a header, without line breaks
+// that would inject other headers.
+func headerSafe(s string) bool {
+	return !strings.ContainsAny(s, "\r\n")
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+
+	"github.com/pkg/errors"
+)
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends emails from from through the SMTP server at addr,
+// host:port, authenticating when username is set. The connection is
+// upgraded with STARTTLS when the server offers it.
+func NewSMTPMailer(addr, username, password, from string) (Mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid smtp address %s", addr)
+	}
+
+	m := &smtpMailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if !headerSafe(msg.To) || !headerSafe(msg.Subject) {
+		return errors.Errorf("invalid email headers for %q", msg.To)
+	}
+
+	// net/smtp takes no context, the send is bounded by the server
+	// timeouts instead
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+	if err != nil {
+		return errors.Wrapf(err, "couldnt send email to %s", msg.To)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+// Package metrics exposes the Prometheus metrics of the service.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"pastebin/store"
+)
+
+const namespace = "pastebin"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	uploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Bytes stored by the bins created.",
+	})
+
+	storeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_call_duration_seconds",
+		Help:      "Store call latency by method.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"method"})
+
+	storeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Failed Store calls by method.",
+	}, []string{"method"})
+
+	janitorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "janitor_runs_total",
+		Help:      "Janitor sweeps by result.",
+	}, []string{"result"})
+)
+
+// Handler serves the metrics to Prometheus.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware counts and times requests under the chi route pattern that
+// served them, so /bins/{alias} is one series whatever the alias.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// binsCollector reads the bins and bytes stored from the running
+// counters of a store, once per scrape.
+type binsCollector struct {
+	svc   store.Store
+	bins  *prometheus.Desc
+	bytes *prometheus.Desc
+}
+
+func (c *binsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bins
+	ch <- c.bytes
+}
+
+func (c *binsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	usage, err := c.svc.GetTotalUsage(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "reading usage for metrics", "err", err)
+		ch <- prometheus.NewInvalidMetric(c.bins, err)
+		ch <- prometheus.NewInvalidMetric(c.bytes, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.bins, prometheus.GaugeValue, float64(usage.Bins))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(usage.Bytes))
+}
+
+// WatchBins exposes the number of live bins of svc and the bytes they
+// store, read from its counters when Prometheus scrapes.
+func WatchBins(svc store.Store) error {
+	return prometheus.Register(&binsCollector{
+		svc: svc,
+		bins: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "active_bins"),
+			"Bins stored and not expired.", nil, nil),
+		bytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "stored_bytes"),
+			"Bytes stored by the bins not expired.", nil, nil),
+	})
+}
+
+// JanitorRun records a janitor sweep and whether it failed.
+func JanitorRun(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	janitorRuns.WithLabelValues(result).Inc()
+}
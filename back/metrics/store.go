@@ -0,0 +1,491 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"pastebin/store"
+)
+
+// instrumentedStore times every call to the Store it wraps and counts
+// its failures. A missing record is not a failure.
+type instrumentedStore struct {
+	next store.Store
+}
+
+// NewStore wraps svc so its calls are measured.
+func NewStore(svc store.Store) store.Store {
+	return &instrumentedStore{next: svc}
+}
+
+func observe(method string, start time.Time, err error) {
+	storeDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		storeErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *instrumentedStore) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	observe("Ping", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) Close() error {
+	return s.next.Close()
+}
+
+func (s *instrumentedStore) CreateBin(ctx context.Context, bin store.Bin) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.CreateBin(ctx, bin)
+	observe("CreateBin", start, err)
+	if err == nil {
+		uploadBytes.Add(float64(res.Size))
+	}
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.GetBinByAlias(ctx, alias)
+	observe("GetBinByAlias", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) PeekBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.PeekBinByAlias(ctx, alias)
+	observe("PeekBinByAlias", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) CountClic(ctx context.Context, bin *store.Bin) error {
+	start := time.Now()
+	err := s.next.CountClic(ctx, bin)
+	observe("CountClic", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) GetBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.GetBinByID(ctx, id)
+	observe("GetBinByID", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetAllBins(ctx context.Context) ([]store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.GetAllBins(ctx)
+	observe("GetAllBins", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetStats(ctx context.Context, top int) (*store.Statistics, error) {
+	start := time.Now()
+	res, err := s.next.GetStats(ctx, top)
+	observe("GetStats", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) RecordView(ctx context.Context, event store.ViewEvent) error {
+	start := time.Now()
+	err := s.next.RecordView(ctx, event)
+	observe("RecordView", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) GetViewSeries(ctx context.Context, binID string, from, to time.Time, granularity string) (*store.ViewSeries, error) {
+	start := time.Now()
+	res, err := s.next.GetViewSeries(ctx, binID, from, to, granularity)
+	observe("GetViewSeries", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) UpdateBin(ctx context.Context, id string, patch store.BinPatch) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.UpdateBin(ctx, id, patch)
+	observe("UpdateBin", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.DeleteBinByID(ctx, id)
+	observe("DeleteBinByID", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetUserByEmail(ctx context.Context, email string) (*store.User, error) {
+	start := time.Now()
+	res, err := s.next.GetUserByEmail(ctx, email)
+	observe("GetUserByEmail", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) CreateUser(ctx context.Context, user store.User) (*store.User, error) {
+	start := time.Now()
+	res, err := s.next.CreateUser(ctx, user)
+	observe("CreateUser", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetAllUsers(ctx context.Context) ([]store.User, error) {
+	start := time.Now()
+	res, err := s.next.GetAllUsers(ctx)
+	observe("GetAllUsers", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) UpdateUser(ctx context.Context, user store.User) (*store.User, error) {
+	start := time.Now()
+	res, err := s.next.UpdateUser(ctx, user)
+	observe("UpdateUser", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteUser(ctx context.Context, email string) error {
+	start := time.Now()
+	err := s.next.DeleteUser(ctx, email)
+	observe("DeleteUser", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	start := time.Now()
+	res, err := s.next.UseTOTPStep(ctx, email, step)
+	observe("UseTOTPStep", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) SetRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	start := time.Now()
+	err := s.next.SetRecoveryCodes(ctx, email, hashes)
+	observe("SetRecoveryCodes", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) UseRecoveryCode(ctx context.Context, email, hash string) (int64, error) {
+	start := time.Now()
+	res, err := s.next.UseRecoveryCode(ctx, email, hash)
+	observe("UseRecoveryCode", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) AddBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.AddBinTags(ctx, id, tags)
+	observe("AddBinTags", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) RemoveBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.RemoveBinTags(ctx, id, tags)
+	observe("RemoveBinTags", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetBinsByTag(ctx context.Context, tag string) ([]store.Bin, error) {
+	start := time.Now()
+	res, err := s.next.GetBinsByTag(ctx, tag)
+	observe("GetBinsByTag", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetTagCounts(ctx context.Context) (map[string]int64, error) {
+	start := time.Now()
+	res, err := s.next.GetTagCounts(ctx)
+	observe("GetTagCounts", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) CreateCollection(ctx context.Context, collection store.Collection) (*store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.CreateCollection(ctx, collection)
+	observe("CreateCollection", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetCollection(ctx context.Context, id string) (*store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.GetCollection(ctx, id)
+	observe("GetCollection", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetCollectionsByOwner(ctx context.Context, owner string) ([]store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.GetCollectionsByOwner(ctx, owner)
+	observe("GetCollectionsByOwner", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) UpdateCollection(ctx context.Context, collection store.Collection) (*store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.UpdateCollection(ctx, collection)
+	observe("UpdateCollection", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteCollection(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.next.DeleteCollection(ctx, id)
+	observe("DeleteCollection", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) AddCollectionBins(ctx context.Context, id string, binIDs []string) (*store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.AddCollectionBins(ctx, id, binIDs)
+	observe("AddCollectionBins", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) RemoveCollectionBins(ctx context.Context, id string, binIDs []string) (*store.Collection, error) {
+	start := time.Now()
+	res, err := s.next.RemoveCollectionBins(ctx, id, binIDs)
+	observe("RemoveCollectionBins", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) CreateUpload(ctx context.Context, upload store.Upload) (*store.Upload, error) {
+	start := time.Now()
+	res, err := s.next.CreateUpload(ctx, upload)
+	observe("CreateUpload", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetUpload(ctx context.Context, id string) (*store.Upload, error) {
+	start := time.Now()
+	res, err := s.next.GetUpload(ctx, id)
+	observe("GetUpload", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) UpdateUpload(ctx context.Context, upload store.Upload) (*store.Upload, error) {
+	start := time.Now()
+	res, err := s.next.UpdateUpload(ctx, upload)
+	observe("UpdateUpload", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteUpload(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.next.DeleteUpload(ctx, id)
+	observe("DeleteUpload", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) GetExpiredUploads(ctx context.Context, before time.Time) ([]store.Upload, error) {
+	start := time.Now()
+	res, err := s.next.GetExpiredUploads(ctx, before)
+	observe("GetExpiredUploads", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) LockUpload(ctx context.Context, id, token string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	res, err := s.next.LockUpload(ctx, id, token, ttl)
+	observe("LockUpload", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) UnlockUpload(ctx context.Context, id, token string) error {
+	start := time.Now()
+	err := s.next.UnlockUpload(ctx, id, token)
+	observe("UnlockUpload", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) CreateAPIToken(ctx context.Context, token store.APIToken) (*store.APIToken, string, error) {
+	start := time.Now()
+	res, secret, err := s.next.CreateAPIToken(ctx, token)
+	observe("CreateAPIToken", start, err)
+
+	return res, secret, err
+}
+
+func (s *instrumentedStore) GetAPIToken(ctx context.Context, secret string) (*store.APIToken, error) {
+	start := time.Now()
+	res, err := s.next.GetAPIToken(ctx, secret)
+	observe("GetAPIToken", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetAPITokensByOwner(ctx context.Context, owner string) ([]store.APIToken, error) {
+	start := time.Now()
+	res, err := s.next.GetAPITokensByOwner(ctx, owner)
+	observe("GetAPITokensByOwner", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteAPIToken(ctx context.Context, owner, id string) error {
+	start := time.Now()
+	err := s.next.DeleteAPIToken(ctx, owner, id)
+	observe("DeleteAPIToken", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) CreateSession(ctx context.Context, session store.Session) (*store.Session, string, error) {
+	start := time.Now()
+	res, secret, err := s.next.CreateSession(ctx, session)
+	observe("CreateSession", start, err)
+
+	return res, secret, err
+}
+
+func (s *instrumentedStore) RotateSession(ctx context.Context, refresh string) (*store.Session, string, error) {
+	start := time.Now()
+	res, secret, err := s.next.RotateSession(ctx, refresh)
+	observe("RotateSession", start, err)
+
+	return res, secret, err
+}
+
+func (s *instrumentedStore) GetSession(ctx context.Context, id string) (*store.Session, error) {
+	start := time.Now()
+	res, err := s.next.GetSession(ctx, id)
+	observe("GetSession", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetSessionsByOwner(ctx context.Context, owner string) ([]store.Session, error) {
+	start := time.Now()
+	res, err := s.next.GetSessionsByOwner(ctx, owner)
+	observe("GetSessionsByOwner", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) DeleteSession(ctx context.Context, owner, id string) error {
+	start := time.Now()
+	err := s.next.DeleteSession(ctx, owner, id)
+	observe("DeleteSession", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) DeleteSessionsByOwner(ctx context.Context, owner string) error {
+	start := time.Now()
+	err := s.next.DeleteSessionsByOwner(ctx, owner)
+	observe("DeleteSessionsByOwner", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) ReserveLoginAttempt(ctx context.Context, subject string, window time.Duration) (*store.LoginFailures, error) {
+	start := time.Now()
+	res, err := s.next.ReserveLoginAttempt(ctx, subject, window)
+	observe("ReserveLoginAttempt", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) RefundLoginAttempt(ctx context.Context, subject string, failures *store.LoginFailures) error {
+	start := time.Now()
+	err := s.next.RefundLoginAttempt(ctx, subject, failures)
+	observe("RefundLoginAttempt", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) ClearLoginFailures(ctx context.Context, subject string) error {
+	start := time.Now()
+	err := s.next.ClearLoginFailures(ctx, subject)
+	observe("ClearLoginFailures", start, err)
+
+	return err
+}
+
+func (s *instrumentedStore) GetUsage(ctx context.Context, owner string) (*store.Usage, error) {
+	start := time.Now()
+	res, err := s.next.GetUsage(ctx, owner)
+	observe("GetUsage", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) GetTotalUsage(ctx context.Context) (*store.Usage, error) {
+	start := time.Now()
+	res, err := s.next.GetTotalUsage(ctx)
+	observe("GetTotalUsage", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) TakeRateToken(ctx context.Context, key string, burst int64, window time.Duration) (*store.RateLimit, error) {
+	start := time.Now()
+	res, err := s.next.TakeRateToken(ctx, key, burst, window)
+	observe("TakeRateToken", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) CreateOneTimeToken(ctx context.Context, purpose, email string, ttl time.Duration) (string, error) {
+	start := time.Now()
+	res, err := s.next.CreateOneTimeToken(ctx, purpose, email, ttl)
+	observe("CreateOneTimeToken", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) PeekOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	start := time.Now()
+	res, err := s.next.PeekOneTimeToken(ctx, purpose, secret)
+	observe("PeekOneTimeToken", start, err)
+
+	return res, err
+}
+
+func (s *instrumentedStore) ConsumeOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	start := time.Now()
+	res, err := s.next.ConsumeOneTimeToken(ctx, purpose, secret)
+	observe("ConsumeOneTimeToken", start, err)
+
+	return res, err
+}
@@ -0,0 +1,75 @@
+package search
+
+import (
+	"context"
+	"sync"
+)
+
+type memoryIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[string]float64
+	docs  map[string][]string
+}
+
+func NewMemoryIndex() Index {
+	return &memoryIndex{
+		terms: map[string]map[string]float64{},
+		docs:  map[string][]string{},
+	}
+}
+
+func (m *memoryIndex) Close() error {
+	return nil
+}
+
+func (m *memoryIndex) Add(ctx context.Context, doc Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(doc.ID)
+
+	terms := []string{}
+	for term, score := range termScores(doc) {
+		posting, ok := m.terms[term]
+		if !ok {
+			posting = map[string]float64{}
+			m.terms[term] = posting
+		}
+		posting[doc.ID] = score
+		terms = append(terms, term)
+	}
+	m.docs[doc.ID] = terms
+
+	return nil
+}
+
+func (m *memoryIndex) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(id)
+
+	return nil
+}
+
+func (m *memoryIndex) remove(id string) {
+	for _, term := range m.docs[id] {
+		delete(m.terms[term], id)
+		if len(m.terms[term]) == 0 {
+			delete(m.terms, term)
+		}
+	}
+	delete(m.docs, id)
+}
+
+func (m *memoryIndex) Search(ctx context.Context, query string) ([]Hit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	postings := []map[string]float64{}
+	for _, term := range queryTerms(query) {
+		postings = append(postings, m.terms[term])
+	}
+
+	return rank(int64(len(m.docs)), postings), nil
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// The index is kept in plain Redis data structures, no module needed:
+// a sorted set of document IDs scored by term frequency per term, and a
+// set of terms per document to remove it again.
+const (
+	docsKey       = "search:docs"
+	termKeyPrefix = "search:term:"
+	docKeyPrefix  = "search:doc:"
+)
+
+type redisIndex struct {
+	client *redis.Client
+}
+
+func NewRedisIndex(ctx context.Context, address string) (Index, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: address,
+	})
+
+	err := rdb.Ping(ctx).Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt ping redis")
+	}
+
+	return &redisIndex{
+		client: rdb,
+	}, nil
+}
+
+func (e *redisIndex) Close() error {
+	return e.client.Close()
+}
+
+func (e *redisIndex) Add(ctx context.Context, doc Document) error {
+	err := e.Remove(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+
+	scores := termScores(doc)
+	terms := make([]interface{}, 0, len(scores))
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for term, score := range scores {
+			pipe.ZAdd(ctx, termKeyPrefix+term, redis.Z{Score: score, Member: doc.ID})
+			terms = append(terms, term)
+		}
+		if len(terms) != 0 {
+			pipe.SAdd(ctx, docKeyPrefix+doc.ID, terms...)
+		}
+		pipe.SAdd(ctx, docsKey, doc.ID)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt index document %s", doc.ID)
+	}
+
+	return nil
+}
+
+func (e *redisIndex) Remove(ctx context.Context, id string) error {
+	terms, err := e.client.SMembers(ctx, docKeyPrefix+id).Result()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt query terms of document %s", id)
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, term := range terms {
+			pipe.ZRem(ctx, termKeyPrefix+term, id)
+		}
+		pipe.Del(ctx, docKeyPrefix+id)
+		pipe.SRem(ctx, docsKey, id)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt remove document %s", id)
+	}
+
+	return nil
+}
+
+func (e *redisIndex) Search(ctx context.Context, query string) ([]Hit, error) {
+	total, err := e.client.SCard(ctx, docsKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt count documents")
+	}
+
+	postings := []map[string]float64{}
+	for _, term := range queryTerms(query) {
+		entries, err := e.client.ZRangeWithScores(ctx, termKeyPrefix+term, 0, -1).Result()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldnt query term %s", term)
+		}
+
+		posting := map[string]float64{}
+		for _, entry := range entries {
+			posting[entry.Member.(string)] = entry.Score
+		}
+		postings = append(postings, posting)
+	}
+
+	return rank(total, postings), nil
+}
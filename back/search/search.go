@@ -0,0 +1,134 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Weights rank a match by the field it was found in.
+var Weights = map[string]float64{
+	"alias":   5,
+	"title":   4,
+	"tags":    3,
+	"files":   2,
+	"content": 1,
+}
+
+// Document is the searchable text of a bin, by field name.
+type Document struct {
+	ID     string
+	Fields map[string]string
+}
+
+// Hit is a document matching a query.
+type Hit struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Index is an inverted index of documents. Adding a document replaces
+// its previous version.
+type Index interface {
+	Add(ctx context.Context, doc Document) error
+	Remove(ctx context.Context, id string) error
+	Search(ctx context.Context, query string) ([]Hit, error)
+	// Close releases the resources of the index.
+	Close() error
+}
+
+// Tokenize splits text into lowercase words of letters and digits.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := []string{}
+	for _, word := range words {
+		if len(word) >= 2 && len(word) <= 64 {
+			tokens = append(tokens, word)
+		}
+	}
+
+	return tokens
+}
+
+// termScores weighs every term of a document by the fields it appears in,
+// damping repeated occurrences.
+func termScores(doc Document) map[string]float64 {
+	scores := map[string]float64{}
+
+	for field, text := range doc.Fields {
+		weight, ok := Weights[field]
+		if !ok {
+			weight = 1
+		}
+
+		counts := map[string]int{}
+		for _, token := range Tokenize(text) {
+			counts[token]++
+		}
+
+		for term, n := range counts {
+			scores[term] += weight * (1 + math.Log(float64(n)))
+		}
+	}
+
+	return scores
+}
+
+// queryTerms returns the distinct terms of a query.
+func queryTerms(query string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, term := range Tokenize(query) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+// rank combines the postings of every query term, keeping the documents
+// matching all of them, scored by tf-idf over total documents.
+func rank(total int64, postings []map[string]float64) []Hit {
+	if len(postings) == 0 {
+		return []Hit{}
+	}
+
+	scores := map[string]float64{}
+	for id := range postings[0] {
+		scores[id] = 0
+	}
+
+	for _, posting := range postings {
+		idf := math.Log(1 + float64(total)/float64(max(1, len(posting))))
+
+		for id := range scores {
+			tf, ok := posting[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += tf * idf
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	return hits
+}
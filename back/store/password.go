@@ -0,0 +1,25 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword hashes password with bcrypt, in its own span as it is
+// slow on purpose.
+func hashPassword(ctx context.Context, password string) (string, error) {
+	_, span := otel.Tracer("pastebin").Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", errors.Wrap(err, "failed to hash password")
+	}
+
+	return string(hashed), nil
+}
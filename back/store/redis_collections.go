@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// collectionOwnerKeyPrefix indexes the collection IDs of each owner.
+const collectionOwnerKeyPrefix = "collections:owner:"
+
+func (e *redisDB) saveCollection(ctx context.Context, collection Collection) error {
+	value, err := json.Marshal(collection)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt json marshal collection %s", collection.ID)
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "collection:"+collection.ID, string(value), 0)
+		pipe.SAdd(ctx, collectionOwnerKeyPrefix+collection.Owner, collection.ID)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt save collection %s", collection.ID)
+	}
+
+	return nil
+}
+
+func (e *redisDB) CreateCollection(ctx context.Context, collection Collection) (*Collection, error) {
+	collection.ID = uuid.NewString()
+	collection.CreatedAt = time.Now()
+	collection.UpdatedAt = collection.CreatedAt
+	if collection.BinIDs == nil {
+		collection.BinIDs = []string{}
+	}
+
+	err := e.saveCollection(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+func (e *redisDB) GetCollection(ctx context.Context, id string) (*Collection, error) {
+	val, err := e.client.Get(ctx, "collection:"+id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for collection %s", id)
+	}
+
+	collection := Collection{}
+	err = json.Unmarshal([]byte(val), &collection)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt parsing collection from string")
+	}
+
+	return &collection, nil
+}
+
+func (e *redisDB) GetCollectionsByOwner(ctx context.Context, owner string) ([]Collection, error) {
+	ids, err := e.client.SMembers(ctx, collectionOwnerKeyPrefix+owner).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for collections of %s", owner)
+	}
+
+	collections := []Collection{}
+
+	for _, id := range ids {
+		collection, err := e.GetCollection(ctx, id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, *collection)
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	return collections, nil
+}
+
+func (e *redisDB) UpdateCollection(ctx context.Context, collection Collection) (*Collection, error) {
+	existing, err := e.GetCollection(ctx, collection.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the name and description can change here
+	existing.Name = collection.Name
+	existing.Description = collection.Description
+	existing.UpdatedAt = time.Now()
+
+	err = e.saveCollection(ctx, *existing)
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (e *redisDB) DeleteCollection(ctx context.Context, id string) error {
+	collection, err := e.GetCollection(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "collection:"+id)
+		pipe.SRem(ctx, collectionOwnerKeyPrefix+collection.Owner, id)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt delete collection %s", id)
+	}
+
+	return nil
+}
+
+func (e *redisDB) AddCollectionBins(ctx context.Context, id string, binIDs []string) (*Collection, error) {
+	collection, err := e.GetCollection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, binID := range binIDs {
+		if !containsString(collection.BinIDs, binID) {
+			collection.BinIDs = append(collection.BinIDs, binID)
+		}
+	}
+	collection.UpdatedAt = time.Now()
+
+	err = e.saveCollection(ctx, *collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+func (e *redisDB) RemoveCollectionBins(ctx context.Context, id string, binIDs []string) (*Collection, error) {
+	collection, err := e.GetCollection(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []string{}
+	for _, binID := range collection.BinIDs {
+		if !containsString(binIDs, binID) {
+			kept = append(kept, binID)
+		}
+	}
+	collection.BinIDs = kept
+	collection.UpdatedAt = time.Now()
+
+	err = e.saveCollection(ctx, *collection)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
@@ -2,62 +2,80 @@ package store
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"log/slog"
+	"mime"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type redisDB struct {
-	client *redis.Client
+	client  *redis.Client
+	binTTL  time.Duration
+	secrets *sealer
 }
 
-func NewRedisDB(ctx context.Context, address string) (Store, error) {
+// NewRedisDB connects to the Redis server at address. Bins are kept for
+// binTTL, user secrets are encrypted with secretsKey.
+func NewRedisDB(ctx context.Context, address string, binTTL time.Duration, secretsKey []byte) (Store, error) {
+	secrets, err := newSealer(secretsKey)
+	if err != nil {
+		return nil, err
+	}
+
 	rdb := redis.NewClient(&redis.Options{
 		Addr: address,
 	})
 
-	err := rdb.Ping(ctx).Err()
+	err = rdb.Ping(ctx).Err()
 	if err != nil {
 		return nil, errors.Wrap(err, "couldnt ping redis")
 	}
 
-	return &redisDB{
-		client: rdb,
-	}, nil
-}
+	rdb.AddHook(logHook{})
 
-func (e *redisDB) GetAllBins(ctx context.Context) ([]Bin, error) {
-	keys, err := e.client.Keys(ctx, "bin:*").Result()
-	if err != nil {
-		return nil, errors.Wrap(err, "couldnt query for bins")
+	db := &redisDB{
+		client:  rdb,
+		binTTL:  binTTL,
+		secrets: secrets,
 	}
 
-	bins := []Bin{}
+	err = db.ensureStats(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, id := range keys {
-		val, err := e.client.Get(ctx, id).Result()
-		if err != nil {
-			return nil, errors.Wrapf(err, "couldnt query for bin %s", id)
-		}
+	err = db.migrateRecoveryCodes(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		t := Bin{}
-		err = json.Unmarshal([]byte(val), &t)
-		if err != nil {
-			return nil, errors.Wrap(err, "couldnt parsing bins from string")
-		}
+	return db, nil
+}
 
-		bins = append(bins, t)
+func (e *redisDB) Ping(ctx context.Context) error {
+	err := e.client.Ping(ctx).Err()
+	if err != nil {
+		return errors.Wrap(err, "couldnt ping redis")
 	}
 
-	return bins, nil
+	return nil
 }
 
-func (e *redisDB) GetStats(ctx context.Context) (*Statistics, error) {
+func (e *redisDB) Close() error {
+	return e.client.Close()
+}
+
+func (e *redisDB) GetAllBins(ctx context.Context) ([]Bin, error) {
 	keys, err := e.client.Keys(ctx, "bin:*").Result()
 	if err != nil {
 		return nil, errors.Wrap(err, "couldnt query for bins")
@@ -72,40 +90,77 @@ func (e *redisDB) GetStats(ctx context.Context) (*Statistics, error) {
 		}
 
 		t := Bin{}
-		err = json.Unmarshal([]byte(val), &t)
+		err = unmarshalBin([]byte(val), &t)
 		if err != nil {
 			return nil, errors.Wrap(err, "couldnt parsing bins from string")
 		}
 
 		bins = append(bins, t)
 	}
-	clics := []ClicByBin{}
-	stats := Statistics{}
-	// tout calculer et retourner un json de mets stats
-	for _, bin := range bins {
-		clic := ClicByBin{BinID: bin.ID, Clic: bin.Clic}
-		clics = append(clics, clic)
+
+	counted := make([]*Bin, len(bins))
+	for i := range bins {
+		counted[i] = &bins[i]
+	}
+	err = e.loadClics(ctx, counted...)
+	if err != nil {
+		return nil, err
 	}
-	lenBins := len(bins)
-	stats = Statistics{BinNumber: int32(lenBins), ClicByBin: clics}
 
-	return &stats, nil
+	return bins, nil
 }
 
 func (e *redisDB) CreateBin(ctx context.Context, bin Bin) (*Bin, error) {
-	keys := e.client.Keys(ctx, "bin:"+bin.Alias+":*").Val()
+	keys := e.client.Keys(ctx, aliasPattern(bin.Alias)).Val()
 	if len(keys) != 0 {
-		return nil, errors.New(bin.Alias + " already exists as an alias")
+		return nil, errors.Wrap(ErrAliasTaken, bin.Alias)
 	}
 
 	bin.ID = uuid.NewString()
-	value, err := json.Marshal(bin)
+
+	if bin.Password != "" {
+		hashed, err := hashPassword(ctx, bin.Password)
+		if err != nil {
+			return nil, err
+		}
+		bin.PasswordHash = hashed
+		bin.Password = ""
+	}
+	bin.Protected = bin.PasswordHash != ""
+
+	// nobody but the creator of a bin without an owner may change it
+	bin.EditToken, bin.EditTokenHash = "", ""
+	if bin.Owner == "" {
+		token, err := newEditToken()
+		if err != nil {
+			return nil, err
+		}
+		bin.EditToken = token
+		bin.EditTokenHash = hashSecret(token)
+	}
+
+	// bins live for the TTL at most
+	expiration := e.binTTL
+	if !bin.ExpiresAt.IsZero() && time.Until(bin.ExpiresAt) < expiration {
+		expiration = time.Until(bin.ExpiresAt)
+	}
+	if expiration <= 0 {
+		return nil, errors.New("the bin would already be expired")
+	}
+	bin.ExpiresAt = time.Now().Add(expiration)
+
+	value, err := marshalBin(bin)
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldnt json marshal bin %s", bin.ID)
 	}
 
-	expiration := 30 * 24 * time.Hour
-	err = e.client.SetEx(ctx, redisKeyFrom(bin), string(value), expiration).Err()
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetEx(ctx, redisKeyFrom(bin), string(value), expiration)
+		indexBin(ctx, pipe, bin, expiration)
+		tagBin(ctx, pipe, bin.ID, bin.Tags, false)
+		countCreation(ctx, pipe, time.Now())
+		return trackBin(ctx, pipe, bin.ID, statsOf(bin), expiration)
+	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldnt create bin %s", bin.ID)
 	}
@@ -113,6 +168,96 @@ func (e *redisDB) CreateBin(ctx context.Context, bin Bin) (*Bin, error) {
 	return &bin, nil
 }
 
+func newEditToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt generate edit token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// storedBin is a bin as saved in Redis, with the fields kept from
+// clients.
+type storedBin struct {
+	Bin
+	PasswordHash  string `json:"password_hash,omitempty"`
+	EditTokenHash string `json:"edit_token_hash,omitempty"`
+}
+
+func marshalBin(bin Bin) ([]byte, error) {
+	bin.Password = ""
+	bin.Protected = bin.PasswordHash != ""
+
+	return json.Marshal(storedBin{Bin: bin, PasswordHash: bin.PasswordHash, EditTokenHash: bin.EditTokenHash})
+}
+
+func unmarshalBin(data []byte, bin *Bin) error {
+	stored := storedBin{}
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	*bin = stored.Bin
+	bin.PasswordHash = stored.PasswordHash
+	bin.EditTokenHash = stored.EditTokenHash
+	bin.Protected = bin.PasswordHash != ""
+	if bin.ContentType == "" && len(bin.Files) == 0 && bin.Filename == "" && bin.Contain != "" {
+		upgradeLegacyBin(bin)
+	}
+
+	return nil
+}
+
+// legacyBlobDir starts the Contain of bins saved before blob storage,
+// which held the path of their file from the working directory rather
+// than its key under the blob root.
+const legacyBlobDir = "files/"
+
+// upgradeLegacyBin turns a bin saved before blob storage, when every bin
+// was a single file, into a file bin. Text bins always have a content
+// type, these never do.
+func upgradeLegacyBin(bin *Bin) {
+	key := strings.TrimPrefix(filepath.ToSlash(bin.Contain), legacyBlobDir)
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	bin.Contain = key
+	bin.Filename = path.Base(key)
+	bin.ContentType = contentType
+	bin.Files = []BinFile{{Name: bin.Filename, Key: key, ContentType: contentType}}
+}
+
+// binIDKeyPrefix indexes the key of each bin, which holds its alias, by
+// the ID of the bin. An entry expires along with its bin.
+const binIDKeyPrefix = "binid:"
+
+// indexBin saves the key of bin under its ID for ttl, the time the bin
+// has left.
+func indexBin(ctx context.Context, pipe redis.Pipeliner, bin Bin, ttl time.Duration) {
+	pipe.Set(ctx, binIDKeyPrefix+bin.ID, redisKeyFrom(bin), ttl)
+}
+
+// aliasPattern matches the key of the bin with alias, whatever its ID,
+// the glob characters of alias matching only themselves.
+func aliasPattern(alias string) string {
+	var b strings.Builder
+	b.WriteString("bin:")
+	for _, r := range alias {
+		if strings.ContainsRune(`*?[]^\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(":*")
+
+	return b.String()
+}
+
 func redisKeyFrom(bin Bin) string {
 	if strings.TrimSpace(bin.Alias) == "" {
 		return "bin:" + bin.ID
@@ -122,72 +267,378 @@ func redisKeyFrom(bin Bin) string {
 }
 
 func (e *redisDB) GetBinByAlias(ctx context.Context, alias string) (*Bin, error) {
+	t, err := e.PeekBinByAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.CountClic(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// clicKeyPrefix+id counts the clicks on a bin, apart from the bin so
+// counting a click never writes the bin back. It expires with the bin.
+// Bins clicked before it kept their count in Clic, which it starts from.
+const clicKeyPrefix = "clic:"
+
+// countClic counts a click on the bin of key KEYS[1], unless it is gone,
+// in the counter KEYS[2] started at ARGV[1], and among the most viewed
+// bins KEYS[3] under ARGV[2]. It returns the clicks, or false for a bin
+// that is gone.
+var countClic = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return false
+end
+
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("SET", KEYS[2], ARGV[1])
+end
+local clic = redis.call("INCR", KEYS[2])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+redis.call("ZINCRBY", KEYS[3], 1, ARGV[2])
+
+return clic
+`)
+
+func (e *redisDB) CountClic(ctx context.Context, bin *Bin) error {
+	// a burned or deleted bin is not counted
+	clic, err := countClic.Run(ctx, e.client, []string{redisKeyFrom(*bin), clicKeyPrefix + bin.ID, statsViewsKey},
+		bin.Clic, bin.ID).Int64()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "couldnt count the clics of bin %s", bin.ID)
+	}
+
+	bin.Clic = int32(clic)
+	return nil
+}
+
+// loadClics merges the click counters into bins.
+func (e *redisDB) loadClics(ctx context.Context, bins ...*Bin) error {
+	if len(bins) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(bins))
+	for i, bin := range bins {
+		keys[i] = clicKeyPrefix + bin.ID
+	}
+
+	clics, err := e.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return errors.Wrap(err, "couldnt query for clics of bins")
+	}
+
+	for i, bin := range bins {
+		val, ok := clics[i].(string)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(val, 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt parse clics of bin %s", bin.ID)
+		}
+		bin.Clic = int32(n)
+	}
+
+	return nil
+}
+
+func (e *redisDB) PeekBinByAlias(ctx context.Context, alias string) (*Bin, error) {
 	if alias == "" {
 		return nil, errors.Errorf("there is no alias provided")
 	}
 
-	keys := e.client.Keys(ctx, "bin:"+alias+":*").Val()
+	keys, err := e.client.Keys(ctx, aliasPattern(alias)).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for bin %s", alias)
+	}
 	if len(keys) == 0 {
-		return nil, errors.New(alias + "couldnt query for bin")
+		return nil, ErrNotFound
 	}
 	val, err := e.client.Get(ctx, keys[0]).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldnt query for bin %s", alias)
 	}
 
 	t := Bin{}
-	err = json.Unmarshal([]byte(val), &t)
+	err = unmarshalBin([]byte(val), &t)
 	if err != nil {
 		return nil, errors.Wrap(err, "couldnt parsing bin from string")
 	}
 
-	//update alias for count the clic number
-	t.Clic = t.Clic + 1
-	value, err := json.Marshal(t)
+	err = e.loadClics(ctx, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// binKeyByID finds the key of a bin from its ID alone, whatever its alias.
+func (e *redisDB) binKeyByID(ctx context.Context, id string) (string, error) {
+	if strings.TrimSpace(id) == "" {
+		return "", errors.Errorf("there is no id provided")
+	}
+
+	key, err := e.client.Get(ctx, binIDKeyPrefix+id).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "couldnt query for bin %s", id)
+	}
+
+	return key, nil
+}
+
+// maxBinAttempts bounds how many times a bin is read again, or an update
+// started over, when the bin changes meanwhile.
+const maxBinAttempts = 10
+
+// getBinByID reads the value of the bin with the given ID, following its
+// key as its alias changes between the reads.
+func (e *redisDB) getBinByID(ctx context.Context, id string) (string, error) {
+	key, err := e.binKeyByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < maxBinAttempts; attempt++ {
+		val, err := e.client.Get(ctx, key).Result()
+		if err == nil {
+			return val, nil
+		}
+		if err != redis.Nil {
+			return "", errors.Wrapf(err, "couldnt query for bin %s", id)
+		}
+
+		renamed, err := e.binKeyByID(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if renamed == key {
+			return "", ErrNotFound
+		}
+		key = renamed
+	}
+
+	return "", errors.Errorf("couldnt query for bin %s, it kept changing", id)
+}
+
+func (e *redisDB) GetBinByID(ctx context.Context, id string) (*Bin, error) {
+	val, err := e.getBinByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t := Bin{}
+	err = unmarshalBin([]byte(val), &t)
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldnt json marshal bin %s", t.ID)
+		return nil, errors.Wrap(err, "couldnt parsing bin from string")
 	}
 
-	err = e.client.Set(ctx, "bin:"+t.Alias+":"+t.ID, string(value), 0).Err()
+	err = e.loadClics(ctx, &t)
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldnt update bin to count the clics %s", t.ID)
+		return nil, err
 	}
 
 	return &t, nil
 }
 
-func (e *redisDB) UpdateBin(ctx context.Context, bin Bin) (*Bin, error) {
-	value, err := json.Marshal(bin)
+// applyPatch merges patch onto a copy of bin, hashing a new password.
+func applyPatch(ctx context.Context, bin Bin, patch BinPatch) (Bin, error) {
+	if patch.Alias != nil {
+		bin.Alias = *patch.Alias
+	}
+	if patch.Title != nil {
+		bin.Title = *patch.Title
+	}
+	if patch.Contain != nil {
+		if len(bin.Files) != 0 || bin.Filename != "" {
+			return bin, ErrNotTextBin
+		}
+		bin.Contain = *patch.Contain
+		bin.Size = int64(len(bin.Contain))
+	}
+	if patch.Visibility != nil {
+		bin.Visibility = *patch.Visibility
+	}
+	if patch.Tags != nil {
+		bin.Tags = append([]string(nil), (*patch.Tags)...)
+	}
+	if patch.Language != nil {
+		bin.Language = *patch.Language
+	}
+	if patch.Password != nil {
+		bin.PasswordHash = ""
+		if *patch.Password != "" {
+			hash, err := hashPassword(ctx, *patch.Password)
+			if err != nil {
+				return bin, err
+			}
+			bin.PasswordHash = hash
+		}
+		bin.Protected = bin.PasswordHash != ""
+	}
+	bin.UpdatedAt = time.Now()
+
+	return bin, nil
+}
+
+func (e *redisDB) UpdateBin(ctx context.Context, id string, patch BinPatch) (*Bin, error) {
+	for attempt := 0; attempt < maxBinAttempts; attempt++ {
+		bin, err := e.updateBin(ctx, id, patch)
+		if err == redis.TxFailedErr {
+			continue
+		}
+
+		return bin, err
+	}
+
+	return nil, errors.Errorf("couldnt update bin %s, it kept changing", id)
+}
+
+// updateBin applies patch to the bin unless it changes meanwhile, which
+// fails with redis.TxFailedErr.
+func (e *redisDB) updateBin(ctx context.Context, id string, patch BinPatch) (*Bin, error) {
+	oldKey, err := e.binKeyByID(ctx, id)
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldnt json marshal bin %s", bin.ID)
+		return nil, err
 	}
 
-	err = e.client.Set(ctx, "bin:*:"+bin.ID, string(value), 0).Err()
+	var updated *Bin
+	err = e.client.Watch(ctx, func(tx *redis.Tx) error {
+		old, err := e.GetBinByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if redisKeyFrom(*old) != oldKey {
+			return redis.TxFailedErr
+		}
+
+		bin, err := applyPatch(ctx, *old, patch)
+		if err != nil {
+			return err
+		}
+		if bin.Alias != old.Alias {
+			if strings.TrimSpace(bin.Alias) == "" {
+				return errors.Errorf("there is no alias provided")
+			}
+			keys := e.client.Keys(ctx, aliasPattern(bin.Alias)).Val()
+			if len(keys) != 0 {
+				return errors.Wrap(ErrAliasTaken, bin.Alias)
+			}
+		}
+
+		value, err := marshalBin(bin)
+		if err != nil {
+			return errors.Wrapf(err, "couldnt json marshal bin %s", bin.ID)
+		}
+
+		// a bin expired, burned or renamed since it was read is read
+		// again, and then found missing or under its new key
+		ttl, err := tx.PTTL(ctx, oldKey).Result()
+		if err != nil {
+			return errors.Wrapf(err, "couldnt query for bin %s", bin.ID)
+		}
+		if ttl == -2 {
+			return redis.TxFailedErr
+		}
+		// -1 is no expiration, kept as such
+		ttl = max(ttl, 0)
+
+		added := []string{}
+		for _, tag := range bin.Tags {
+			if !containsString(old.Tags, tag) {
+				added = append(added, tag)
+			}
+		}
+		removed := []string{}
+		for _, tag := range old.Tags {
+			if !containsString(bin.Tags, tag) {
+				removed = append(removed, tag)
+			}
+		}
+
+		// the key changes with the alias
+		newKey := redisKeyFrom(bin)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if newKey != oldKey {
+				pipe.Del(ctx, oldKey)
+				pipe.Set(ctx, newKey, string(value), ttl)
+				indexBin(ctx, pipe, bin, ttl)
+			} else {
+				pipe.Set(ctx, newKey, string(value), redis.KeepTTL)
+			}
+			tagBin(ctx, pipe, bin.ID, removed, true)
+			tagBin(ctx, pipe, bin.ID, added, false)
+			countBin(ctx, pipe, statsOf(*old), -1)
+			return trackBin(ctx, pipe, bin.ID, statsOf(bin), ttl)
+		})
+		if err == redis.TxFailedErr {
+			return err
+		}
+		if err != nil {
+			return errors.Wrapf(err, "couldnt update bin %s", bin.ID)
+		}
+
+		updated = &bin
+		return nil
+	}, oldKey)
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldnt update bin %s", bin.ID)
+		return nil, err
 	}
 
-	return &bin, nil
+	return updated, nil
 }
 
 func (e *redisDB) DeleteBinByID(ctx context.Context, id string) (*Bin, error) {
-	val, err := e.client.Get(ctx, "bin:*:"+id).Result()
+	t, err := e.GetBinByID(ctx, id)
 	if err != nil {
-		return nil, errors.Wrapf(err, "couldnt query for bin %s", id)
+		return nil, err
 	}
 
-	t := Bin{}
-	err = json.Unmarshal([]byte(val), &t)
+	viewKeys, err := e.client.Keys(ctx, viewKeyPrefix+t.ID+":*").Result()
 	if err != nil {
-		return nil, errors.Wrap(err, "couldnt parsing bin from string")
+		return nil, errors.Wrapf(err, "couldnt query for views of bin %s", t.ID)
 	}
 
-	err = e.client.Del(ctx, "bin:"+t.ID).Err()
+	// only one of concurrent deletes gets the bin, which makes burning a
+	// bin after reading it safe
+	deleted, err := e.client.Del(ctx, redisKeyFrom(*t)).Result()
 	if err != nil {
 		return nil, errors.Wrapf(err, "couldnt delete bin %s", t.ID)
 	}
+	if deleted == 0 {
+		return nil, ErrNotFound
+	}
 
-	return &t, nil
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(viewKeys) != 0 {
+			pipe.Del(ctx, viewKeys...)
+		}
+		pipe.Del(ctx, binIDKeyPrefix+t.ID, clicKeyPrefix+t.ID)
+		untrackBin(ctx, pipe, t.ID, statsOf(*t))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt delete bin %s", t.ID)
+	}
+
+	return t, nil
 }
 
 func (e *redisDB) SetBinExpiration(ctx context.Context, BD string, expiration time.Duration) error {
@@ -195,22 +646,102 @@ func (e *redisDB) SetBinExpiration(ctx context.Context, BD string, expiration ti
 	return e.client.Expire(ctx, key, expiration).Err()
 }
 
-func (e *redisDB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+// storedUser is a user as saved in Redis, with its password hash and its
+// sealed TOTP secret. Users saved before the hash had its own field kept
+// it in mot_de_passe, and before they had a set their recovery codes in
+// recovery_codes.
+type storedUser struct {
+	User
+	PasswordHash  string   `json:"password_hash,omitempty"`
+	LegacyHash    string   `json:"mot_de_passe,omitempty"`
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+func (e *redisDB) marshalUser(user User) ([]byte, error) {
+	user.Password = ""
+
+	stored := storedUser{
+		User:         user,
+		PasswordHash: user.PasswordHash,
+	}
+	switch {
+	case user.TOTPSecret != "":
+		sealed, err := e.secrets.seal(user.TOTPSecret, user.Email)
+		if err != nil {
+			return nil, err
+		}
+		stored.TOTPSecret = sealed
+	case user.TOTPEnabled:
+		// a secret that could not be opened is kept as it was, until the
+		// second factor is disabled
+		stored.TOTPSecret = user.sealedTOTP
+	}
+
+	return json.Marshal(stored)
+}
+
+func (e *redisDB) unmarshalUser(data []byte, user *User) error {
+	stored := storedUser{}
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+
+	*user = stored.User
+	user.PasswordHash = stored.PasswordHash
+	if user.PasswordHash == "" {
+		user.PasswordHash = stored.LegacyHash
+	}
+	user.legacyRecoveryCodes = stored.RecoveryCodes
+
+	if stored.TOTPSecret != "" {
+		// A secret that cannot be opened, such as after a change of key,
+		// is kept sealed and fails the one-time codes of the user.
+		user.TOTPSecret, err = e.secrets.open(stored.TOTPSecret, user.Email)
+		if err != nil {
+			slog.Warn("couldnt open the TOTP secret", "user", user.Email, "err", err)
+			user.sealedTOTP = stored.TOTPSecret
+			user.totpErr = errors.Wrapf(err, "couldnt open the TOTP secret of %s", user.Email)
+		}
+	}
+
+	return nil
+}
+
+// userKey returns the key of the user with the given email.
+func (e *redisDB) userKey(ctx context.Context, email string) (string, error) {
 	if email == "" {
-		return nil, errors.Errorf("there is no email provided")
+		return "", errors.Errorf("there is no email provided")
 	}
 
-	keys := e.client.Keys(ctx, "user:"+email+":*").Val()
+	keys, err := e.client.Keys(ctx, "user:"+email+":*").Result()
+	if err != nil {
+		return "", errors.Wrapf(err, "couldnt query for user %s", email)
+	}
 	if len(keys) == 0 {
-			return nil, errors.New(email + "couldnt query for user")
+		return "", ErrNotFound
+	}
+
+	return keys[0], nil
+}
+
+func (e *redisDB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	key, err := e.userKey(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := e.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
 	}
-	val, err := e.client.Get(ctx, keys[0]).Result()
 	if err != nil {
 			return nil, errors.Wrapf(err, "couldnt query for user %s", email)
 	}
 
 	user := User{}
-	err = json.Unmarshal([]byte(val), &user)
+	err = e.unmarshalUser([]byte(val), &user)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not parse user from string")
 	}
@@ -221,15 +752,21 @@ func (e *redisDB) GetUserByEmail(ctx context.Context, email string) (*User, erro
 func (e *redisDB) CreateUser(ctx context.Context, user User) (*User, error) {
 	userID := uuid.NewString()
 	user.ID = userID
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
+	user.CreatedAt = time.Now()
 
 	//hâcher le mot de passe
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.MotDePasse), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(ctx, user.Password)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to hash password")
+		return nil, err
 	}
-	user.MotDePasse = string(hashedPassword)
+	user.Password = ""
+	user.PasswordHash = hashedPassword
 
-	userData, err := json.Marshal(user)
+	userData, err := e.marshalUser(user)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal user data")
 	}
@@ -258,7 +795,7 @@ func (e *redisDB) GetAllUsers(ctx context.Context) ([]User, error) {
 			}
 
 			u := User{}
-			err = json.Unmarshal([]byte(val), &u)
+			err = e.unmarshalUser([]byte(val), &u)
 			if err != nil {
 					return nil, errors.Wrap(err, "couldnt parsing user from string")
 			}
@@ -269,10 +806,214 @@ func (e *redisDB) GetAllUsers(ctx context.Context) ([]User, error) {
 	return users, nil
 }
 
-func (e *redisDB) DropAllUsers(ctx context.Context) error {
-	_, err := e.client.FlushDB(ctx).Result()
+func (e *redisDB) UpdateUser(ctx context.Context, user User) (*User, error) {
+	key, err := e.userKey(ctx, user.Email)
 	if err != nil {
-		return errors.Wrap(err, "failed to drop all users")
+		return nil, err
 	}
+
+	if user.Password != "" {
+		user.PasswordHash, err = hashPassword(ctx, user.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = ""
+	}
+
+	userData, err := e.marshalUser(user)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal user data")
+	}
+
+	err = e.client.Set(ctx, key, string(userData), redis.KeepTTL).Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt update user %s", user.Email)
+	}
+
+	return &user, nil
+}
+
+func (e *redisDB) DeleteUser(ctx context.Context, email string) error {
+	key, err := e.userKey(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	err = e.client.Del(ctx, key, recoveryKeyPrefix+email).Err()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt delete user %s", email)
+	}
+
+	return nil
+}
+
+// uploadExpiryKey is a sorted set of upload IDs scored by their
+// expiration, so expired sessions can be found after their data is gone.
+const uploadExpiryKey = "uploads:expiry"
+
+// uploadGrace keeps an expired upload record around long enough for the
+// janitor to remove its blob.
+const uploadGrace = time.Hour
+
+func (e *redisDB) saveUpload(ctx context.Context, upload Upload) error {
+	value, err := json.Marshal(upload)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt json marshal upload %s", upload.ID)
+	}
+
+	ttl := time.Until(upload.ExpiresAt) + uploadGrace
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "upload:"+upload.ID, string(value), ttl)
+		pipe.ZAdd(ctx, uploadExpiryKey, redis.Z{
+			Score:  float64(upload.ExpiresAt.Unix()),
+			Member: upload.ID,
+		})
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt save upload %s", upload.ID)
+	}
+
+	return nil
+}
+
+func (e *redisDB) CreateUpload(ctx context.Context, upload Upload) (*Upload, error) {
+	upload.ID = uuid.NewString()
+	upload.CreatedAt = time.Now()
+
+	err := e.saveUpload(ctx, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (e *redisDB) getUpload(ctx context.Context, id string) (*Upload, error) {
+	val, err := e.client.Get(ctx, "upload:"+id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for upload %s", id)
+	}
+
+	upload := Upload{}
+	err = json.Unmarshal([]byte(val), &upload)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt parsing upload from string")
+	}
+
+	return &upload, nil
+}
+
+func (e *redisDB) GetUpload(ctx context.Context, id string) (*Upload, error) {
+	upload, err := e.getUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return upload, nil
+}
+
+func (e *redisDB) UpdateUpload(ctx context.Context, upload Upload) (*Upload, error) {
+	err := e.saveUpload(ctx, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (e *redisDB) DeleteUpload(ctx context.Context, id string) error {
+	_, err := e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, "upload:"+id)
+		pipe.ZRem(ctx, uploadExpiryKey, id)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt delete upload %s", id)
+	}
+
+	return nil
+}
+
+func (e *redisDB) GetExpiredUploads(ctx context.Context, before time.Time) ([]Upload, error) {
+	ids, err := e.client.ZRangeByScore(ctx, uploadExpiryKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt query for expired uploads")
+	}
+
+	uploads := []Upload{}
+
+	for _, id := range ids {
+		upload, err := e.getUpload(ctx, id)
+		if err == ErrNotFound {
+			// the record outlived its grace period, only the ID is left
+			uploads = append(uploads, Upload{ID: id})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the upload may have been resumed since it was indexed
+		if upload.ExpiresAt.After(before) {
+			continue
+		}
+
+		uploads = append(uploads, *upload)
+	}
+
+	return uploads, nil
+}
+
+// uploadLockKeyPrefix+id holds the token of the request appending to an
+// upload, shared by every instance of the API.
+const uploadLockKeyPrefix = "upload:lock:"
+
+// lockUpload sets the lock to ARGV[1] unless another token holds it.
+var lockUpload = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return 0
+end
+
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// unlockUpload deletes the lock if ARGV[1] still holds it.
+var unlockUpload = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+
+return 0
+`)
+
+func (e *redisDB) LockUpload(ctx context.Context, id, token string, ttl time.Duration) (bool, error) {
+	locked, err := lockUpload.Run(ctx, e.client, []string{uploadLockKeyPrefix + id}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, errors.Wrapf(err, "couldnt lock upload %s", id)
+	}
+
+	return locked == 1, nil
+}
+
+func (e *redisDB) UnlockUpload(ctx context.Context, id, token string) error {
+	err := unlockUpload.Run(ctx, e.client, []string{uploadLockKeyPrefix + id}, token).Err()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt unlock upload %s", id)
+	}
+
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// logHook logs the Redis commands at debug level, and their failures,
+// with the context of the call so they carry its request ID.
+type logHook struct{}
+
+func (logHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (logHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		logCommand(ctx, cmd.Name(), 1, start, err)
+
+		return err
+	}
+}
+
+func (logHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		logCommand(ctx, "pipeline", len(cmds), start, err)
+
+		return err
+	}
+}
+
+func logCommand(ctx context.Context, name string, count int, start time.Time, err error) {
+	if err != nil && err != redis.Nil {
+		slog.WarnContext(ctx, "redis command failed", "command", name, "commands", count, "duration", time.Since(start), "err", err)
+		return
+	}
+
+	slog.DebugContext(ctx, "redis command", "command", name, "commands", count, "duration", time.Since(start))
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// loginFailuresKeyPrefix+subject is a hash of the failure count and the
+// time of the last failure, in Unix milliseconds.
+const loginFailuresKeyPrefix = "login:failures:"
+
+// reserveLoginAttempt counts an attempt as a failure at ARGV[1] and
+// returns the count and last failure before it.
+var reserveLoginAttempt = redis.NewScript(`
+local state = redis.call("HMGET", KEYS[1], "count", "last")
+
+redis.call("HINCRBY", KEYS[1], "count", 1)
+redis.call("HSET", KEYS[1], "last", ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+
+return {tonumber(state[1]) or 0, tonumber(state[2]) or 0}
+`)
+
+// refundLoginAttempt uncounts an attempt reserved at ARGV[1], putting
+// back the last failure ARGV[2] unless another attempt came since.
+var refundLoginAttempt = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+
+if redis.call("HINCRBY", KEYS[1], "count", -1) <= 0 then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+
+if redis.call("HGET", KEYS[1], "last") == ARGV[1] then
+	redis.call("HSET", KEYS[1], "last", ARGV[2])
+end
+
+return 1
+`)
+
+func (e *redisDB) ReserveLoginAttempt(ctx context.Context, subject string, window time.Duration) (*LoginFailures, error) {
+	now := time.Now()
+
+	res, err := reserveLoginAttempt.Run(ctx, e.client, []string{loginFailuresKeyPrefix + subject},
+		now.UnixMilli(), window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt reserve login attempt of %s", subject)
+	}
+
+	failures := &LoginFailures{Count: res[0], Reserved: time.UnixMilli(now.UnixMilli())}
+	if res[1] != 0 {
+		failures.Last = time.UnixMilli(res[1])
+	}
+
+	return failures, nil
+}
+
+func (e *redisDB) RefundLoginAttempt(ctx context.Context, subject string, failures *LoginFailures) error {
+	var last int64
+	if !failures.Last.IsZero() {
+		last = failures.Last.UnixMilli()
+	}
+
+	err := refundLoginAttempt.Run(ctx, e.client, []string{loginFailuresKeyPrefix + subject},
+		failures.Reserved.UnixMilli(), last).Err()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt refund login attempt of %s", subject)
+	}
+
+	return nil
+}
+
+func (e *redisDB) ClearLoginFailures(ctx context.Context, subject string) error {
+	err := e.client.Del(ctx, loginFailuresKeyPrefix+subject).Err()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt clear login failures of %s", subject)
+	}
+
+	return nil
+}
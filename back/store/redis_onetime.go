@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// A one time token is saved under oneTimeKeyPrefix+purpose+":"+hash,
+// holding the email it was issued to. The hash of the latest token of an
+// email is kept under oneTimeLatestKeyPrefix+purpose+":"+email, so that
+// issuing a token revokes the previous one.
+const (
+	oneTimeKeyPrefix       = "onetime:"
+	oneTimeLatestKeyPrefix = "onetime:latest:"
+)
+
+func newOneTimeSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt generate one time token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (e *redisDB) CreateOneTimeToken(ctx context.Context, purpose, email string, ttl time.Duration) (string, error) {
+	secret, err := newOneTimeSecret()
+	if err != nil {
+		return "", err
+	}
+
+	hash := hashSecret(secret)
+	latestKey := oneTimeLatestKeyPrefix + purpose + ":" + email
+
+	previous, err := e.client.Get(ctx, latestKey).Result()
+	if err != nil && err != redis.Nil {
+		return "", errors.Wrapf(err, "couldnt query for %s tokens of %s", purpose, email)
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if previous != "" {
+			pipe.Del(ctx, oneTimeKeyPrefix+purpose+":"+previous)
+		}
+		pipe.Set(ctx, oneTimeKeyPrefix+purpose+":"+hash, email, ttl)
+		pipe.Set(ctx, latestKey, hash, ttl)
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "couldnt create %s token of %s", purpose, email)
+	}
+
+	return secret, nil
+}
+
+func (e *redisDB) PeekOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	email, err := e.client.Get(ctx, oneTimeKeyPrefix+purpose+":"+hashSecret(secret)).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "couldnt query for %s token", purpose)
+	}
+
+	return email, nil
+}
+
+func (e *redisDB) ConsumeOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	hash := hashSecret(secret)
+
+	// only one of concurrent consumers gets the token
+	email, err := e.client.GetDel(ctx, oneTimeKeyPrefix+purpose+":"+hash).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "couldnt consume %s token", purpose)
+	}
+
+	e.client.Del(ctx, oneTimeLatestKeyPrefix+purpose+":"+email)
+
+	return email, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitKeyPrefix+key is a hash of the tokens left in a bucket and
+// the time they were counted, in Unix milliseconds.
+const rateLimitKeyPrefix = "ratelimit:"
+
+// takeRateToken refills the bucket for the time elapsed since it was
+// last counted, then takes a token when there is one. It returns whether
+// a token was taken and the tokens left, as a string since Redis would
+// truncate a number.
+var takeRateToken = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * burst / window)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], window)
+
+return {allowed, tostring(tokens)}
+`)
+
+func (e *redisDB) TakeRateToken(ctx context.Context, key string, burst int64, window time.Duration) (*RateLimit, error) {
+	res, err := takeRateToken.Run(ctx, e.client, []string{rateLimitKeyPrefix + key},
+		burst, window.Milliseconds(), time.Now().UnixMilli()).Slice()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt take rate limit token of %s", key)
+	}
+
+	allowed, _ := res[0].(int64)
+	left, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt parse rate limit tokens of %s", key)
+	}
+
+	// one token comes back every window/burst
+	perToken := float64(window) / float64(burst)
+	limit := &RateLimit{
+		Allowed:   allowed == 1,
+		Limit:     burst,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     time.Duration((float64(burst) - tokens) * perToken),
+	}
+	if !limit.Allowed {
+		limit.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+
+	return limit, nil
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Each session is saved under sessionKeyPrefix+ID, listed per owner in
+// sessionOwnerKeyPrefix+owner. Every refresh token of the family, current
+// or rotated, points to its session from sessionRefreshKeyPrefix+hash
+// until the session expires, so reused tokens are recognized.
+const (
+	sessionKeyPrefix        = "session:"
+	sessionRefreshKeyPrefix = "session:refresh:"
+	sessionOwnerKeyPrefix   = "sessions:owner:"
+)
+
+// refreshSecretPrefix makes the refresh tokens easy to spot.
+const refreshSecretPrefix = "rt_"
+
+// storedSession is a session as saved in Redis, with the hash of its
+// current refresh token.
+type storedSession struct {
+	Session
+	RefreshHash string `json:"refresh_hash"`
+}
+
+func newRefreshSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt generate refresh token")
+	}
+
+	return refreshSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// saveSession writes session with the refresh token secret as its
+// current one, in pipe.
+func saveSession(ctx context.Context, pipe redis.Pipeliner, session Session, secret string) error {
+	stored := storedSession{Session: session, RefreshHash: hashSecret(secret)}
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt json marshal session %s", session.ID)
+	}
+
+	ttl := time.Until(session.ExpiresAt)
+	pipe.Set(ctx, sessionKeyPrefix+session.ID, string(value), ttl)
+	pipe.Set(ctx, sessionRefreshKeyPrefix+stored.RefreshHash, session.ID, ttl)
+	pipe.SAdd(ctx, sessionOwnerKeyPrefix+session.Owner, session.ID)
+
+	return nil
+}
+
+func (e *redisDB) CreateSession(ctx context.Context, session Session) (*Session, string, error) {
+	if time.Until(session.ExpiresAt) <= 0 {
+		return nil, "", errors.New("the session would already be expired")
+	}
+
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session.ID = uuid.NewString()
+	session.CreatedAt = time.Now()
+	session.RefreshedAt = session.CreatedAt
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return saveSession(ctx, pipe, session, secret)
+	})
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "couldnt create session %s", session.ID)
+	}
+
+	return &session, secret, nil
+}
+
+func (e *redisDB) getSession(ctx context.Context, client redis.Cmdable, id string) (*storedSession, error) {
+	val, err := client.Get(ctx, sessionKeyPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for session %s", id)
+	}
+
+	session := storedSession{}
+	err = json.Unmarshal([]byte(val), &session)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt parsing session from string")
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return &session, nil
+}
+
+func (e *redisDB) RotateSession(ctx context.Context, refresh string) (*Session, string, error) {
+	hash := hashSecret(refresh)
+
+	id, err := e.client.Get(ctx, sessionRefreshKeyPrefix+hash).Result()
+	if err == redis.Nil {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "couldnt query for refresh token")
+	}
+
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var rotated *Session
+	reused := false
+
+	// two refreshes with the same token must not both succeed
+	err = e.client.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := e.getSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if stored.RefreshHash != hash {
+			reused = true
+			return nil
+		}
+
+		session := stored.Session
+		session.RefreshedAt = time.Now()
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return saveSession(ctx, pipe, session, secret)
+		})
+		if err != nil {
+			return err
+		}
+
+		rotated = &session
+		return nil
+	}, sessionKeyPrefix+id)
+	if err == redis.TxFailedErr {
+		// another refresh won the race with the same token
+		reused = true
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		session, err := e.getSession(ctx, e.client, id)
+		if err == nil {
+			err = e.DeleteSession(ctx, session.Owner, id)
+		}
+		if err != nil && err != ErrNotFound {
+			return nil, "", err
+		}
+
+		return nil, "", ErrRefreshReused
+	}
+
+	return rotated, secret, nil
+}
+
+func (e *redisDB) GetSession(ctx context.Context, id string) (*Session, error) {
+	session, err := e.getSession(ctx, e.client, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session.Session, nil
+}
+
+func (e *redisDB) GetSessionsByOwner(ctx context.Context, owner string) ([]Session, error) {
+	ids, err := e.client.SMembers(ctx, sessionOwnerKeyPrefix+owner).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for sessions of %s", owner)
+	}
+
+	sessions := []Session{}
+	for _, id := range ids {
+		session, err := e.getSession(ctx, e.client, id)
+		if err == ErrNotFound {
+			// the session expired, forget it
+			e.client.SRem(ctx, sessionOwnerKeyPrefix+owner, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session.Session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (e *redisDB) DeleteSession(ctx context.Context, owner, id string) error {
+	session, err := e.getSession(ctx, e.client, id)
+	if err != nil {
+		return err
+	}
+	if session.Owner != owner {
+		return ErrNotFound
+	}
+
+	// the rotated refresh tokens now point to a missing session
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKeyPrefix+id, sessionRefreshKeyPrefix+session.RefreshHash)
+		pipe.SRem(ctx, sessionOwnerKeyPrefix+owner, id)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt delete session %s", id)
+	}
+
+	return nil
+}
+
+func (e *redisDB) DeleteSessionsByOwner(ctx context.Context, owner string) error {
+	ids, err := e.client.SMembers(ctx, sessionOwnerKeyPrefix+owner).Result()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt query for sessions of %s", owner)
+	}
+
+	for _, id := range ids {
+		err := e.DeleteSession(ctx, owner, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return e.client.Del(ctx, sessionOwnerKeyPrefix+owner).Err()
+}
@@ -0,0 +1,429 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// The global statistics are counters maintained as bins are created,
+// changed, viewed and deleted, so reading them never walks the bins.
+// Every counted bin has an entry in statsBinsKey, and its expiration in
+// statsExpiryKey so bins dropped by Redis can be uncounted. The bins and
+// bytes of each owner are counted in statsUsageKeyPrefix+owner. The
+// tags of a bin are kept with its statistics so a bin Redis expired is
+// untagged when uncounted.
+// statsVersionKey holds the statsVersion the statistics were built for.
+const (
+	statsVersionKey = "stats:version"
+	statsCountKey   = "stats:bins"
+	statsBytesKey   = "stats:bytes"
+	statsCreatedKey = "stats:created"
+	statsTypesKey   = "stats:types"
+	statsOwnersKey  = "stats:owners"
+	statsViewsKey   = "stats:views"
+	statsBinsKey    = "stats:bin"
+	statsExpiryKey  = "stats:expiry"
+
+	statsUsageKeyPrefix = "stats:usage:"
+
+	// statsDays is how many days of bin creations GetStats returns.
+	statsDays = 30
+)
+
+// statsVersion is the version of the statistics schema, to raise
+// whenever counters are added or what binStats keeps changes so they
+// are rebuilt for the existing bins. The rebuild also writes the ID
+// index of the bins.
+//
+// 1: bin, byte, type, owner, view and creation counters
+// 2: usage of each owner
+// 3: tags of each bin
+// 4: index of the bins by ID
+const statsVersion = 4
+
+// legacyStatsKeys marked built statistics before statsVersionKey.
+var legacyStatsKeys = []string{"stats:built", "stats:built:usage", "stats:built:tags"}
+
+// expiryBuckets splits the counted bins by time left before they expire,
+// the rest being counted as "later" or "never".
+var expiryBuckets = []struct {
+	name  string
+	limit time.Duration
+}{
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// binStats is what the statistics remember of a counted bin.
+type binStats struct {
+	Alias       string   `json:"alias"`
+	Owner       string   `json:"owner"`
+	ContentType string   `json:"content_type"`
+	Size        int64    `json:"size"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+func statsOf(bin Bin) binStats {
+	return binStats{
+		Alias:       bin.Alias,
+		Owner:       bin.Owner,
+		ContentType: bin.ContentType,
+		Size:        bin.Size,
+		Tags:        bin.Tags,
+	}
+}
+
+// expiryScore is the statsExpiryKey score of a bin expiring in ttl, a
+// ttl of zero or less meaning never.
+func expiryScore(ttl time.Duration) float64 {
+	if ttl <= 0 {
+		return math.Inf(1)
+	}
+
+	return float64(time.Now().Add(ttl).Unix())
+}
+
+// countBin adds (delta 1) or removes (delta -1) a bin from the counters.
+func countBin(ctx context.Context, pipe redis.Pipeliner, info binStats, delta int64) {
+	pipe.IncrBy(ctx, statsCountKey, delta)
+	pipe.IncrBy(ctx, statsBytesKey, delta*info.Size)
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "unknown"
+	}
+	pipe.HIncrBy(ctx, statsTypesKey, contentType, delta)
+
+	if info.Owner != "" {
+		pipe.ZIncrBy(ctx, statsOwnersKey, float64(delta), info.Owner)
+		if delta < 0 {
+			pipe.ZRemRangeByScore(ctx, statsOwnersKey, "-inf", "0")
+		}
+		pipe.HIncrBy(ctx, statsUsageKeyPrefix+info.Owner, "bins", delta)
+		pipe.HIncrBy(ctx, statsUsageKeyPrefix+info.Owner, "bytes", delta*info.Size)
+	}
+}
+
+// saveBinStats writes what the statistics remember of a counted bin.
+func saveBinStats(ctx context.Context, pipe redis.Pipeliner, id string, info binStats) error {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt json marshal statistics of bin %s", id)
+	}
+
+	pipe.HSet(ctx, statsBinsKey, id, string(value))
+
+	return nil
+}
+
+// trackBin records a newly counted bin.
+func trackBin(ctx context.Context, pipe redis.Pipeliner, id string, info binStats, ttl time.Duration) error {
+	err := saveBinStats(ctx, pipe, id, info)
+	if err != nil {
+		return err
+	}
+
+	pipe.ZAdd(ctx, statsExpiryKey, redis.Z{Score: expiryScore(ttl), Member: id})
+	countBin(ctx, pipe, info, 1)
+
+	return nil
+}
+
+// untrackBin forgets a counted bin, untagging it.
+func untrackBin(ctx context.Context, pipe redis.Pipeliner, id string, info binStats) {
+	tagBin(ctx, pipe, id, info.Tags, true)
+	pipe.HDel(ctx, statsBinsKey, id)
+	pipe.ZRem(ctx, statsExpiryKey, id)
+	pipe.ZRem(ctx, statsViewsKey, id)
+	countBin(ctx, pipe, info, -1)
+}
+
+// countCreation records a bin created now.
+func countCreation(ctx context.Context, pipe redis.Pipeliner, at time.Time) {
+	pipe.HIncrBy(ctx, statsCreatedKey, at.UTC().Format("2006-01-02"), 1)
+}
+
+// ensureStats builds the statistics from the stored bins the first time
+// a store runs against a database, or against statistics of an older
+// statsVersion. Concurrent rebuilds both yield the same statistics.
+func (e *redisDB) ensureStats(ctx context.Context) error {
+	version, err := e.client.Get(ctx, statsVersionKey).Int()
+	if err != nil && err != redis.Nil {
+		return errors.Wrap(err, "couldnt check statistics version")
+	}
+	if version >= statsVersion {
+		return nil
+	}
+
+	err = e.rebuildStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, statsVersionKey, statsVersion, 0)
+		pipe.Del(ctx, legacyStatsKeys...)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldnt save statistics version")
+	}
+
+	return nil
+}
+
+func (e *redisDB) rebuildStats(ctx context.Context) error {
+	bins, err := e.GetAllBins(ctx)
+	if err != nil {
+		return err
+	}
+
+	ttls := make([]*redis.DurationCmd, len(bins))
+	_, err = e.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, bin := range bins {
+			ttls[i] = pipe.TTL(ctx, redisKeyFrom(bin))
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldnt query for bin expirations")
+	}
+
+	usageKeys, err := e.client.Keys(ctx, statsUsageKeyPrefix+"*").Result()
+	if err != nil {
+		return errors.Wrap(err, "couldnt query for usage counters")
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, statsCountKey, statsBytesKey, statsCreatedKey, statsTypesKey,
+			statsOwnersKey, statsViewsKey, statsBinsKey, statsExpiryKey)
+		if len(usageKeys) != 0 {
+			pipe.Del(ctx, usageKeys...)
+		}
+
+		for i, bin := range bins {
+			indexBin(ctx, pipe, bin, max(ttls[i].Val(), 0))
+
+			err := trackBin(ctx, pipe, bin.ID, statsOf(bin), ttls[i].Val())
+			if err != nil {
+				return err
+			}
+
+			if bin.Clic > 0 {
+				pipe.ZAdd(ctx, statsViewsKey, redis.Z{Score: float64(bin.Clic), Member: bin.ID})
+			}
+			if !bin.CreatedAt.IsZero() {
+				countCreation(ctx, pipe, bin.CreatedAt)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldnt build statistics")
+	}
+
+	return nil
+}
+
+// claimExpiredBins takes the bins expired by ARGV[1] out of the expiry
+// set KEYS[1] and their statistics out of KEYS[2], returning the ID and
+// statistics of each, or an empty string when a bin had none. A bin is
+// claimed by only one of concurrent callers, which alone uncounts it.
+var claimExpiredBins = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+local claimed = {}
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	local info = redis.call("HGET", KEYS[2], id)
+	redis.call("HDEL", KEYS[2], id)
+	table.insert(claimed, id)
+	table.insert(claimed, info or "")
+end
+return claimed
+`)
+
+// uncountExpiredBins removes from the statistics the bins Redis expired.
+func (e *redisDB) uncountExpiredBins(ctx context.Context) error {
+	claimed, err := claimExpiredBins.Run(ctx, e.client, []string{statsExpiryKey, statsBinsKey},
+		time.Now().Unix()).StringSlice()
+	if err != nil {
+		return errors.Wrap(err, "couldnt claim expired bins")
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i+1 < len(claimed); i += 2 {
+			id, val := claimed[i], claimed[i+1]
+			if val == "" {
+				continue
+			}
+
+			info := binStats{}
+			err := json.Unmarshal([]byte(val), &info)
+			if err != nil {
+				return errors.Wrapf(err, "couldnt parse statistics of bin %s", id)
+			}
+
+			untrackBin(ctx, pipe, id, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldnt uncount expired bins")
+	}
+
+	return nil
+}
+
+func (e *redisDB) GetStats(ctx context.Context, top int) (*Statistics, error) {
+	err := e.uncountExpiredBins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	days := make([]string, statsDays)
+	for i := range days {
+		days[i] = now.AddDate(0, 0, i-statsDays+1).Format("2006-01-02")
+	}
+
+	var count, bytes *redis.StringCmd
+	var created *redis.SliceCmd
+	var types *redis.MapStringStringCmd
+	var owners *redis.IntCmd
+	var views *redis.ZSliceCmd
+	expiry := make([]*redis.IntCmd, len(expiryBuckets)+2)
+
+	_, err = e.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.Get(ctx, statsCountKey)
+		bytes = pipe.Get(ctx, statsBytesKey)
+		created = pipe.HMGet(ctx, statsCreatedKey, days...)
+		types = pipe.HGetAll(ctx, statsTypesKey)
+		owners = pipe.ZCard(ctx, statsOwnersKey)
+		views = pipe.ZRevRangeWithScores(ctx, statsViewsKey, 0, int64(top)-1)
+
+		min := "-inf"
+		for i, bucket := range expiryBuckets {
+			max := strconv.FormatInt(now.Add(bucket.limit).Unix(), 10)
+			expiry[i] = pipe.ZCount(ctx, statsExpiryKey, min, max)
+			min = "(" + max
+		}
+		expiry[len(expiryBuckets)] = pipe.ZCount(ctx, statsExpiryKey, min, "(+inf")
+		expiry[len(expiryBuckets)+1] = pipe.ZCount(ctx, statsExpiryKey, "+inf", "+inf")
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "couldnt query for statistics")
+	}
+
+	binNumber, _ := count.Int64()
+	storageBytes, _ := bytes.Int64()
+
+	stats := Statistics{
+		BinNumber:    int32(binNumber),
+		StorageBytes: storageBytes,
+		ActiveUsers:  owners.Val(),
+		BinsPerDay:   map[string]int64{},
+		ContentTypes: map[string]int64{},
+		Expiry:       map[string]int64{},
+		ClicByBin:    []ClicByBin{},
+	}
+
+	for i, day := range days {
+		val, _ := created.Val()[i].(string)
+		n, _ := strconv.ParseInt(val, 10, 64)
+		stats.BinsPerDay[day] = n
+	}
+
+	for contentType, val := range types.Val() {
+		n, _ := strconv.ParseInt(val, 10, 64)
+		if n > 0 {
+			stats.ContentTypes[contentType] = n
+		}
+	}
+
+	for i, bucket := range expiryBuckets {
+		stats.Expiry[bucket.name] = expiry[i].Val()
+	}
+	stats.Expiry["later"] = expiry[len(expiryBuckets)].Val()
+	stats.Expiry["never"] = expiry[len(expiryBuckets)+1].Val()
+
+	ids := []string{}
+	for _, entry := range views.Val() {
+		ids = append(ids, entry.Member.(string))
+	}
+	if len(ids) != 0 {
+		infos, err := e.client.HMGet(ctx, statsBinsKey, ids...).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "couldnt query for statistics of viewed bins")
+		}
+
+		for i, entry := range views.Val() {
+			info := binStats{}
+			val, _ := infos[i].(string)
+			json.Unmarshal([]byte(val), &info)
+
+			stats.ClicByBin = append(stats.ClicByBin, ClicByBin{
+				BinID: ids[i],
+				Alias: info.Alias,
+				Clic:  int32(entry.Score),
+			})
+		}
+	}
+
+	stats.TagCounts, err = e.GetTagCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func (e *redisDB) GetUsage(ctx context.Context, owner string) (*Usage, error) {
+	err := e.uncountExpiredBins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := e.client.HGetAll(ctx, statsUsageKeyPrefix+owner).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for usage of %s", owner)
+	}
+
+	usage := &Usage{}
+	usage.Bins, _ = strconv.ParseInt(fields["bins"], 10, 64)
+	usage.Bytes, _ = strconv.ParseInt(fields["bytes"], 10, 64)
+
+	return usage, nil
+}
+
+func (e *redisDB) GetTotalUsage(ctx context.Context) (*Usage, error) {
+	err := e.uncountExpiredBins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := e.client.MGet(ctx, statsCountKey, statsBytesKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt query for total usage")
+	}
+
+	usage := &Usage{}
+	count, _ := vals[0].(string)
+	bytes, _ := vals[1].(string)
+	usage.Bins, _ = strconv.ParseInt(count, 10, 64)
+	usage.Bytes, _ = strconv.ParseInt(bytes, 10, 64)
+
+	return usage, nil
+}
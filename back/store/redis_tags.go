@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Each tag has a set of the IDs of its bins, and tagCountsKey keeps the
+// number of bins per tag. The bins Redis expired are untagged as they
+// are uncounted from the statistics.
+const (
+	tagKeyPrefix = "tag:"
+	tagCountsKey = "tags:count"
+)
+
+// saveBin writes back an existing bin, keeping its expiration.
+func (e *redisDB) saveBin(ctx context.Context, bin Bin) error {
+	value, err := marshalBin(bin)
+	if err != nil {
+		return errors.Wrapf(err, "couldnt json marshal bin %s", bin.ID)
+	}
+
+	// a bin expired or burned since it was read must not come back
+	saved, err := e.client.SetXX(ctx, redisKeyFrom(bin), string(value), redis.KeepTTL).Result()
+	if err != nil {
+		return errors.Wrapf(err, "couldnt update bin %s", bin.ID)
+	}
+	if !saved {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// tagBin records id as a bin of every tag, or unrecords it when untag.
+func tagBin(ctx context.Context, pipe redis.Pipeliner, id string, tags []string, untag bool) {
+	for _, tag := range tags {
+		if untag {
+			pipe.SRem(ctx, tagKeyPrefix+tag, id)
+			pipe.ZIncrBy(ctx, tagCountsKey, -1, tag)
+		} else {
+			pipe.SAdd(ctx, tagKeyPrefix+tag, id)
+			pipe.ZIncrBy(ctx, tagCountsKey, 1, tag)
+		}
+	}
+
+	if untag {
+		pipe.ZRemRangeByScore(ctx, tagCountsKey, "-inf", "0")
+	}
+}
+
+func (e *redisDB) AddBinTags(ctx context.Context, id string, tags []string) (*Bin, error) {
+	bin, err := e.GetBinByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	added := []string{}
+	for _, tag := range tags {
+		if !containsString(bin.Tags, tag) && !containsString(added, tag) {
+			added = append(added, tag)
+		}
+	}
+	if len(added) == 0 {
+		return bin, nil
+	}
+	bin.Tags = append(bin.Tags, added...)
+
+	err = e.saveBin(ctx, *bin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		tagBin(ctx, pipe, bin.ID, added, false)
+		return saveBinStats(ctx, pipe, bin.ID, statsOf(*bin))
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt tag bin %s", bin.ID)
+	}
+
+	return bin, nil
+}
+
+func (e *redisDB) RemoveBinTags(ctx context.Context, id string, tags []string) (*Bin, error) {
+	bin, err := e.GetBinByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []string{}
+	removed := []string{}
+	for _, tag := range bin.Tags {
+		if containsString(tags, tag) {
+			removed = append(removed, tag)
+		} else {
+			kept = append(kept, tag)
+		}
+	}
+	if len(removed) == 0 {
+		return bin, nil
+	}
+	bin.Tags = kept
+
+	err = e.saveBin(ctx, *bin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		tagBin(ctx, pipe, bin.ID, removed, true)
+		return saveBinStats(ctx, pipe, bin.ID, statsOf(*bin))
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt untag bin %s", bin.ID)
+	}
+
+	return bin, nil
+}
+
+func (e *redisDB) GetBinsByTag(ctx context.Context, tag string) ([]Bin, error) {
+	ids, err := e.client.SMembers(ctx, tagKeyPrefix+tag).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for tag %s", tag)
+	}
+
+	bins := []Bin{}
+
+	for _, id := range ids {
+		bin, err := e.GetBinByID(ctx, id)
+		if err == ErrNotFound {
+			// the bin expired, forget it, its count is taken off as
+			// it is uncounted from the statistics
+			err = e.client.SRem(ctx, tagKeyPrefix+tag, id).Err()
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldnt untag bin %s", id)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bins = append(bins, *bin)
+	}
+
+	return bins, nil
+}
+
+func (e *redisDB) GetTagCounts(ctx context.Context) (map[string]int64, error) {
+	err := e.uncountExpiredBins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := e.client.ZRangeWithScores(ctx, tagCountsKey, 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt query for tag counts")
+	}
+
+	counts := map[string]int64{}
+	for _, entry := range entries {
+		counts[entry.Member.(string)] = int64(entry.Score)
+	}
+
+	return counts, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Each token is saved under apiTokenKeyPrefix+ID, found from its secret
+// through apiTokenHashKeyPrefix+hash and listed per owner in
+// apiTokenOwnerKeyPrefix+owner.
+const (
+	apiTokenKeyPrefix      = "apitoken:"
+	apiTokenHashKeyPrefix  = "apitoken:hash:"
+	apiTokenOwnerKeyPrefix = "apitokens:owner:"
+)
+
+// apiTokenSecretPrefix makes the secrets easy to spot, in leaked logs
+// for instance.
+const apiTokenSecretPrefix = "pb_"
+
+// storedAPIToken is a token as saved in Redis, with the hash of its
+// secret.
+type storedAPIToken struct {
+	APIToken
+	Hash string `json:"hash"`
+}
+
+// hashSecret hashes a token secret. The secrets are random and long, a
+// fast hash is enough and lets them be looked up.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPITokenSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt generate token")
+	}
+
+	return apiTokenSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (e *redisDB) CreateAPIToken(ctx context.Context, token APIToken) (*APIToken, string, error) {
+	secret, err := newAPITokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token.ID = uuid.NewString()
+	token.Prefix = secret[:len(apiTokenSecretPrefix)+4]
+	token.CreatedAt = time.Now()
+
+	stored := storedAPIToken{APIToken: token, Hash: hashSecret(secret)}
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "couldnt json marshal token %s", token.ID)
+	}
+
+	// Redis forgets expired tokens by itself
+	var ttl time.Duration
+	if !token.ExpiresAt.IsZero() {
+		ttl = time.Until(token.ExpiresAt)
+		if ttl <= 0 {
+			return nil, "", errors.New("the token would already be expired")
+		}
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, apiTokenKeyPrefix+token.ID, string(value), ttl)
+		pipe.Set(ctx, apiTokenHashKeyPrefix+stored.Hash, token.ID, ttl)
+		pipe.SAdd(ctx, apiTokenOwnerKeyPrefix+token.Owner, token.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "couldnt create token %s", token.ID)
+	}
+
+	return &token, secret, nil
+}
+
+func (e *redisDB) getAPIToken(ctx context.Context, id string) (*storedAPIToken, error) {
+	val, err := e.client.Get(ctx, apiTokenKeyPrefix+id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for token %s", id)
+	}
+
+	token := storedAPIToken{}
+	err = json.Unmarshal([]byte(val), &token)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt parsing token from string")
+	}
+
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return &token, nil
+}
+
+func (e *redisDB) GetAPIToken(ctx context.Context, secret string) (*APIToken, error) {
+	id, err := e.client.Get(ctx, apiTokenHashKeyPrefix+hashSecret(secret)).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt query for token")
+	}
+
+	token, err := e.getAPIToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token.APIToken, nil
+}
+
+func (e *redisDB) GetAPITokensByOwner(ctx context.Context, owner string) ([]APIToken, error) {
+	ids, err := e.client.SMembers(ctx, apiTokenOwnerKeyPrefix+owner).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for tokens of %s", owner)
+	}
+
+	tokens := []APIToken{}
+	for _, id := range ids {
+		token, err := e.getAPIToken(ctx, id)
+		if err == ErrNotFound {
+			// the token expired, forget it
+			e.client.SRem(ctx, apiTokenOwnerKeyPrefix+owner, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token.APIToken)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (e *redisDB) DeleteAPIToken(ctx context.Context, owner, id string) error {
+	token, err := e.getAPIToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if token.Owner != owner {
+		return ErrNotFound
+	}
+
+	_, err = e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, apiTokenKeyPrefix+id, apiTokenHashKeyPrefix+token.Hash)
+		pipe.SRem(ctx, apiTokenOwnerKeyPrefix+owner, id)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt delete token %s", id)
+	}
+
+	return nil
+}
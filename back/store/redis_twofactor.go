@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// The time step of an accepted one-time code of a user is kept under
+// totpUsedKeyPrefix+email+":"+step until the code expires, the hashes of
+// their unused recovery codes in the set recoveryKeyPrefix+email.
+const (
+	totpUsedKeyPrefix = "totp:used:"
+	recoveryKeyPrefix = "recovery:"
+
+	// totpUsedTTL outlasts the steps a code is accepted in.
+	totpUsedTTL = 5 * time.Minute
+)
+
+// recoveryMigratedKey marks the recovery codes kept in the users records
+// as moved to their sets.
+const recoveryMigratedKey = "recovery:migrated"
+
+func (e *redisDB) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	// only one of concurrent requests gets the step
+	ok, err := e.client.SetNX(ctx, totpUsedKeyPrefix+email+":"+strconv.FormatInt(step, 10), 1, totpUsedTTL).Result()
+	if err != nil {
+		return false, errors.Wrapf(err, "couldnt use one-time code of %s", email)
+	}
+
+	return ok, nil
+}
+
+func (e *redisDB) SetRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	key := recoveryKeyPrefix + email
+
+	_, err := e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		if len(hashes) != 0 {
+			pipe.SAdd(ctx, key, hashes)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldnt save recovery codes of %s", email)
+	}
+
+	return nil
+}
+
+func (e *redisDB) UseRecoveryCode(ctx context.Context, email, hash string) (int64, error) {
+	key := recoveryKeyPrefix + email
+
+	var removed, left *redis.IntCmd
+	_, err := e.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// only one of concurrent requests removes the code
+		removed = pipe.SRem(ctx, key, hash)
+		left = pipe.SCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldnt use recovery code of %s", email)
+	}
+	if removed.Val() == 0 {
+		return 0, ErrNotFound
+	}
+
+	return left.Val(), nil
+}
+
+// migrateRecoveryCodes moves the recovery codes users kept in their
+// record before they had a set of their own.
+func (e *redisDB) migrateRecoveryCodes(ctx context.Context) error {
+	migrated, err := e.client.Exists(ctx, recoveryMigratedKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "couldnt check recovery codes migration")
+	}
+	if migrated != 0 {
+		return nil
+	}
+
+	users, err := e.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if len(user.legacyRecoveryCodes) == 0 {
+			continue
+		}
+
+		err = e.client.SAdd(ctx, recoveryKeyPrefix+user.Email, user.legacyRecoveryCodes).Err()
+		if err != nil {
+			return errors.Wrapf(err, "couldnt migrate recovery codes of %s", user.Email)
+		}
+
+		// saving the user drops the codes from its record
+		_, err = e.UpdateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = e.client.Set(ctx, recoveryMigratedKey, 1, 0).Err()
+	if err != nil {
+		return errors.Wrap(err, "couldnt save recovery codes migration")
+	}
+
+	return nil
+}
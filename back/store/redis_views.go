@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Views of a bin are kept under viewKeyPrefix+binID: the raw events in a
+// capped stream, and a hash of counters plus a HyperLogLog of visitors
+// for every hour and every day.
+const (
+	viewKeyPrefix = "views:"
+
+	maxViewEvents  = 10000
+	hourRetention  = 14 * 24 * time.Hour
+	dayRetention   = 400 * 24 * time.Hour
+	referrerPrefix = "referrer:"
+	agentPrefix    = "agent:"
+)
+
+// bucketStart truncates t to the start of its hour or day, in UTC.
+func bucketStart(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	return t.Truncate(time.Hour)
+}
+
+func nextBucket(t time.Time, granularity string) time.Time {
+	if granularity == GranularityDay {
+		return t.AddDate(0, 0, 1)
+	}
+
+	return t.Add(time.Hour)
+}
+
+func bucketKey(binID string, t time.Time, granularity string) string {
+	layout := "2006010215"
+	if granularity == GranularityDay {
+		layout = "20060102"
+	}
+
+	return viewKeyPrefix + binID + ":" + granularity + ":" + t.Format(layout)
+}
+
+// recordView counts a view of a bin still stored, found through its ID
+// index. Nothing outlives the bin: the events and the counters expire
+// with it, or after their retention when it comes first.
+var recordView = redis.NewScript(`
+local key = redis.call("GET", KEYS[1])
+if not key then
+	return false
+end
+local ttl = redis.call("PTTL", key)
+if ttl == -2 then
+	return false
+end
+
+local function expire(k, retention)
+	if ttl > 0 and ttl < retention then
+		retention = ttl
+	end
+	redis.call("PEXPIRE", k, retention)
+end
+
+redis.call("XADD", KEYS[2], "MAXLEN", "~", ARGV[1], "*",
+	"time", ARGV[2], "referrer", ARGV[3], "user_agent", ARGV[4], "visitor", ARGV[5])
+expire(KEYS[2], tonumber(ARGV[9]))
+
+for i = 0, 1 do
+	local counters, visitors, retention = KEYS[3 + 2 * i], KEYS[4 + 2 * i], tonumber(ARGV[8 + i])
+	redis.call("HINCRBY", counters, "views", 1)
+	if ARGV[3] ~= "" then
+		redis.call("HINCRBY", counters, ARGV[6], 1)
+	end
+	redis.call("HINCRBY", counters, ARGV[7], 1)
+	expire(counters, retention)
+
+	redis.call("PFADD", visitors, ARGV[5])
+	expire(visitors, retention)
+end
+
+return true
+`)
+
+func (e *redisDB) RecordView(ctx context.Context, event ViewEvent) error {
+	hour := bucketKey(event.BinID, bucketStart(event.Time, GranularityHour), GranularityHour)
+	day := bucketKey(event.BinID, bucketStart(event.Time, GranularityDay), GranularityDay)
+
+	// a burned or deleted bin is not recorded
+	err := recordView.Run(ctx, e.client,
+		[]string{
+			binIDKeyPrefix + event.BinID,
+			viewKeyPrefix + event.BinID + ":events",
+			hour, hour + ":visitors",
+			day, day + ":visitors",
+		},
+		maxViewEvents,
+		event.Time.UTC().Format(time.RFC3339Nano),
+		event.Referrer,
+		event.UserAgent,
+		event.Visitor,
+		referrerPrefix+event.Referrer,
+		agentPrefix+event.UserAgent,
+		hourRetention.Milliseconds(),
+		dayRetention.Milliseconds(),
+	).Err()
+	if err != nil && err != redis.Nil {
+		return errors.Wrapf(err, "couldnt record view of bin %s", event.BinID)
+	}
+
+	return nil
+}
+
+func (e *redisDB) GetViewSeries(ctx context.Context, binID string, from, to time.Time, granularity string) (*ViewSeries, error) {
+	if granularity != GranularityHour && granularity != GranularityDay {
+		return nil, errors.Errorf("unknown granularity %q", granularity)
+	}
+
+	series := ViewSeries{
+		BinID:       binID,
+		Granularity: granularity,
+		From:        from.UTC(),
+		To:          to.UTC(),
+		Points:      []ViewPoint{},
+	}
+
+	starts := []time.Time{}
+	for t := bucketStart(from, granularity); t.Before(to); t = nextBucket(t, granularity) {
+		starts = append(starts, t)
+	}
+	if len(starts) == 0 {
+		return &series, nil
+	}
+
+	counters := make([]*redis.MapStringStringCmd, len(starts))
+	visitors := make([]*redis.IntCmd, len(starts))
+	visitorKeys := make([]string, len(starts))
+	var unique *redis.IntCmd
+
+	_, err := e.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, start := range starts {
+			key := bucketKey(binID, start, granularity)
+			visitorKeys[i] = key + ":visitors"
+			counters[i] = pipe.HGetAll(ctx, key)
+			visitors[i] = pipe.PFCount(ctx, visitorKeys[i])
+		}
+		unique = pipe.PFCount(ctx, visitorKeys...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldnt query for views of bin %s", binID)
+	}
+
+	for i, start := range starts {
+		point := ViewPoint{
+			Time:           start,
+			UniqueVisitors: visitors[i].Val(),
+		}
+
+		for field, val := range counters[i].Val() {
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "couldnt parse view counter %s", field)
+			}
+
+			switch {
+			case field == "views":
+				point.Views = n
+			case strings.HasPrefix(field, referrerPrefix):
+				if point.Referrers == nil {
+					point.Referrers = map[string]int64{}
+				}
+				point.Referrers[strings.TrimPrefix(field, referrerPrefix)] = n
+			case strings.HasPrefix(field, agentPrefix):
+				if point.UserAgents == nil {
+					point.UserAgents = map[string]int64{}
+				}
+				point.UserAgents[strings.TrimPrefix(field, agentPrefix)] = n
+			}
+		}
+
+		series.Views += point.Views
+		series.Points = append(series.Points, point)
+	}
+	series.UniqueVisitors = unique.Val()
+
+	return &series, nil
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoSecretsKey is returned when saving a secret the Store cannot
+// encrypt, having been given no key.
+var ErrNoSecretsKey = errors.New("no key to encrypt secrets")
+
+// sealedPrefix versions the format of the sealed secrets.
+const sealedPrefix = "v1:"
+
+// sealer encrypts the secrets kept in the Store with AES-256-GCM, bound
+// to the record they belong to.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// newSealer derives the encryption key from key, returning nil for an
+// empty key.
+func newSealer(key []byte) (*sealer, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	derived := sha256.Sum256(append([]byte("pastebin secrets:"), key...))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt create secrets cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldnt create secrets cipher")
+	}
+
+	return &sealer{aead: aead}, nil
+}
+
+// seal encrypts plain for the record named owner.
+func (s *sealer) seal(plain, owner string) (string, error) {
+	if s == nil {
+		return "", ErrNoSecretsKey
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt generate nonce")
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), []byte(owner))
+
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a secret sealed for the record named owner.
+func (s *sealer) open(sealed, owner string) (string, error) {
+	if s == nil {
+		return "", ErrNoSecretsKey
+	}
+
+	encoded, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return "", errors.New("unknown sealed secret format")
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", errors.New("malformed sealed secret")
+	}
+
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, []byte(owner))
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt decrypt secret")
+	}
+
+	return string(plain), nil
+}
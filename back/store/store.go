@@ -2,44 +2,521 @@ package store
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type Bin struct {
-	ID        string    `json:"id"`
-	Alias     string    `json:"alias"`
-	Contain   string    `json:"contain"`
-	Clic      int32     `json:"clic"`
-	UserId    User      `json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	Alias       string    `json:"alias"`
+	Title       string    `json:"title,omitempty"`
+	Contain     string    `json:"contain"`
+	Filename    string    `json:"filename,omitempty"`
+	Files       []BinFile `json:"files,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Clic        int32     `json:"clic"`
+	Owner       string    `json:"owner,omitempty"`
+	Visibility  string    `json:"visibility,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	// Language names the syntax of a text bin, for highlighting.
+	Language string `json:"language,omitempty"`
+	// BurnAfterRead bins are deleted once their content is first read.
+	BurnAfterRead bool `json:"burn_after_read,omitempty"`
+	// Protected bins need their password to be read.
+	Protected bool `json:"protected,omitempty"`
+	// Password is the clear password protecting a new bin, hashed by
+	// CreateBin. PasswordHash is never sent to clients.
+	Password     string    `json:"-"`
+	PasswordHash string    `json:"-"`
+	UserId       User      `json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// ExpiresAt is when the bin is deleted. A new bin with an ExpiresAt
+	// later than the bin TTL of the Store, or none, gets the TTL.
+	ExpiresAt time.Time `json:"expires_at"`
+	// EditToken lets the creator of a bin without an owner change it.
+	// CreateBin issues it, it is only kept hashed in EditTokenHash.
+	EditToken     string `json:"-"`
+	EditTokenHash string `json:"-"`
+}
+
+// BinPatch holds the changes to a bin, nil fields are kept. Only text
+// bins have their Contain changed. An empty Password removes the
+// protection of the bin.
+type BinPatch struct {
+	Alias      *string   `json:"alias,omitempty"`
+	Title      *string   `json:"title,omitempty"`
+	Contain    *string   `json:"content,omitempty"`
+	Visibility *string   `json:"visibility,omitempty"`
+	Tags       *[]string `json:"tags,omitempty"`
+	Language   *string   `json:"language,omitempty"`
+	Password   *string   `json:"password,omitempty"`
+}
+
+// UnmarshalJSON accepts the text of a patch under content, or under
+// contain as it was first sent.
+func (p *BinPatch) UnmarshalJSON(data []byte) error {
+	type plain BinPatch
+	var v struct {
+		plain
+		LegacyContain *string `json:"contain"`
+	}
+
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+
+	*p = BinPatch(v.plain)
+	if p.Contain == nil {
+		p.Contain = v.LegacyContain
+	}
+
+	return nil
+}
+
+// Visibility of a bin. Public bins are listed and searchable by anyone,
+// unlisted bins are reachable by alias only and private bins are only
+// visible to their owner. An empty visibility means public.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// BinFile is one of the files held by a file bin.
+type BinFile struct {
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// PublicBin is what clients are shown of a bin. Content holds the text
+// of text bins, file bins are read through their files.
+type PublicBin struct {
+	ID            string          `json:"id"`
+	Alias         string          `json:"alias"`
+	Title         string          `json:"title,omitempty"`
+	Content       string          `json:"content,omitempty"`
+	Filename      string          `json:"filename,omitempty"`
+	Files         []PublicBinFile `json:"files,omitempty"`
+	ContentType   string          `json:"content_type,omitempty"`
+	Size          int64           `json:"size,omitempty"`
+	Owner         string          `json:"owner,omitempty"`
+	Visibility    string          `json:"visibility,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+	Language      string          `json:"language,omitempty"`
+	BurnAfterRead bool            `json:"burn_after_read,omitempty"`
+	Protected     bool            `json:"protected,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	// EditToken is only given to the creator of a bin without an owner.
+	EditToken string `json:"edit_token,omitempty"`
+}
+
+// PublicBinFile is a file of a PublicBin, without its blob key.
+type PublicBinFile struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
 }
 
+// Public returns the fields of b clients may see.
+func (b *Bin) Public() PublicBin {
+	pub := PublicBin{
+		ID:            b.ID,
+		Alias:         b.Alias,
+		Title:         b.Title,
+		Filename:      b.Filename,
+		ContentType:   b.ContentType,
+		Size:          b.Size,
+		Owner:         b.Owner,
+		Visibility:    b.Visibility,
+		Tags:          b.Tags,
+		Language:      b.Language,
+		BurnAfterRead: b.BurnAfterRead,
+		Protected:     b.Protected,
+		CreatedAt:     b.CreatedAt,
+		UpdatedAt:     b.UpdatedAt,
+		ExpiresAt:     b.ExpiresAt,
+		EditToken:     b.EditToken,
+	}
+
+	// the Contain of file bins is the key of their blob
+	if len(b.Files) == 0 && b.Filename == "" {
+		pub.Content = b.Contain
+	}
+	for _, f := range b.Files {
+		pub.Files = append(pub.Files, PublicBinFile{Name: f.Name, Size: f.Size, ContentType: f.ContentType})
+	}
+
+	return pub
+}
+
+// CheckEditToken reports whether token is the edit token of b.
+func (b *Bin) CheckEditToken(token string) bool {
+	return b.EditTokenHash != "" && token != "" &&
+		subtle.ConstantTimeCompare([]byte(hashSecret(token)), []byte(b.EditTokenHash)) == 1
+}
+
+// PublicBins returns the public view of each of bins.
+func PublicBins(bins []Bin) []PublicBin {
+	pub := make([]PublicBin, 0, len(bins))
+	for i := range bins {
+		pub = append(pub, bins[i].Public())
+	}
+
+	return pub
+}
+
+// Statistics are global aggregates over the live bins. ClicByBin holds
+// the most viewed bins, BinsPerDay the bins created on each of the last
+// days and Expiry the bins by time left before they expire. ActiveUsers
+// counts the users owning at least one bin.
 type Statistics struct {
-	BinNumber int32 		`json:"bin_number"`
-	ClicByBin []ClicByBin  `json:"clic_by_bin"`
+	BinNumber    int32            `json:"bin_number"`
+	StorageBytes int64            `json:"storage_bytes"`
+	ActiveUsers  int64            `json:"active_users"`
+	BinsPerDay   map[string]int64 `json:"bins_per_day"`
+	ClicByBin    []ClicByBin      `json:"clic_by_bin"`
+	ContentTypes map[string]int64 `json:"content_types"`
+	Expiry       map[string]int64 `json:"expiry"`
+	TagCounts    map[string]int64 `json:"tag_counts"`
 }
 
 type ClicByBin struct {
 	BinID string `json:"bin_id"`
-	Clic int32   `json:"clic"`
+	Alias string `json:"alias,omitempty"`
+	Clic  int32  `json:"clic"`
+}
+
+// Collection is a named group of bins owned by a user.
+type Collection struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner"`
+	BinIDs      []string  `json:"bin_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ViewEvent is one view of a bin. Visitor is a hash of the client
+// address, never the address itself.
+type ViewEvent struct {
+	BinID     string    `json:"bin_id"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent"`
+	Visitor   string    `json:"visitor"`
+}
+
+// Granularities of the view rollups.
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// ViewPoint holds the views of a bin during one hour or one day.
+// UniqueVisitors is an estimate.
+type ViewPoint struct {
+	Time           time.Time        `json:"time"`
+	Views          int64            `json:"views"`
+	UniqueVisitors int64            `json:"unique_visitors"`
+	Referrers      map[string]int64 `json:"referrers,omitempty"`
+	UserAgents     map[string]int64 `json:"user_agents,omitempty"`
 }
 
+// ViewSeries is the time series of the views of a bin between From and
+// To.
+type ViewSeries struct {
+	BinID          string      `json:"bin_id"`
+	Granularity    string      `json:"granularity"`
+	From           time.Time   `json:"from"`
+	To             time.Time   `json:"to"`
+	Views          int64       `json:"views"`
+	UniqueVisitors int64       `json:"unique_visitors"`
+	Points         []ViewPoint `json:"points"`
+}
+
+// Roles of the users, from the least to the most privileged. Users saved
+// without a role are plain users.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// User is a user as kept by the store. Password is the clear password of
+// a new user, hashed by CreateUser. Neither it nor PasswordHash is ever
+// sent to clients, which get a PublicUser.
 type User struct {
-	ID        		string    	`json:"id"`
-	Email     		string    	`json:"email"`
-	MotDePasse      string    	`json:"mot_de_passe"`
+	ID           string `json:"id"`
+	Email        string `json:"email"`
+	Password     string `json:"-"`
+	PasswordHash string `json:"-"`
+	Role         string `json:"role"`
+	// Disabled users can neither log in nor use their tokens.
+	Disabled      bool      `json:"disabled"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+	// TOTPSecret is held in clear only in memory, the Store saves it
+	// encrypted. TOTPEnabled is set once the user confirmed a first code.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+
+	// sealedTOTP keeps a secret the Store could not open, so that saving
+	// the user does not lose it, and totpErr tells why.
+	sealedTOTP string
+	totpErr    error
+	// legacyRecoveryCodes are the recovery codes kept in the record of
+	// the user before they were moved to a set.
+	legacyRecoveryCodes []string
+}
+
+// TOTPError returns why the TOTP secret of an enabled second factor
+// could not be opened, or nil.
+func (u *User) TOTPError() error {
+	return u.totpErr
+}
+
+// PublicUser is a user as shown by the API.
+type PublicUser struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	Disabled      bool      `json:"disabled"`
+	EmailVerified bool      `json:"email_verified"`
+	TOTPEnabled   bool      `json:"totp_enabled"`
+	CreatedAt     time.Time `json:"created_at,omitempty"`
 }
 
+// Public returns the fields of u clients may see.
+func (u *User) Public() PublicUser {
+	role := u.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	return PublicUser{
+		ID:            u.ID,
+		Email:         u.Email,
+		Role:          role,
+		Disabled:      u.Disabled,
+		EmailVerified: u.EmailVerified,
+		TOTPEnabled:   u.TOTPEnabled,
+		CreatedAt:     u.CreatedAt,
+	}
+}
+
+// Upload is the state of a resumable upload session. Once Offset
+// reaches Length the uploaded blob becomes a regular bin.
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	Owner     string            `json:"owner,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Scopes of an API token. Tokens with ScopeBinsWrite may also read, and
+// ScopeAdmin allows everything.
+const (
+	ScopeBinsRead  = "bins:read"
+	ScopeBinsWrite = "bins:write"
+	ScopeAdmin     = "admin"
+)
+
+// APIToken is a personal access token for scripts. Only a hash of its
+// secret is kept, Prefix is enough to recognize it. A zero ExpiresAt
+// never expires.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Session is a login, kept alive by rotating refresh tokens until
+// ExpiresAt. The refresh tokens of a session form a family: presenting a
+// token that was already rotated revokes the session.
+type Session struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// LoginFailures counts the failed logins of a subject, an IP or an
+// account, since the first one of the current window.
+type LoginFailures struct {
+	Count int64     `json:"count"`
+	Last  time.Time `json:"last"`
+	// Reserved is when ReserveLoginAttempt counted the attempt these
+	// failures preceded.
+	Reserved time.Time `json:"-"`
+}
+
+// Usage is what an owner stores, counting the bins not expired yet.
+type Usage struct {
+	Bins  int64 `json:"bins"`
+	Bytes int64 `json:"bytes"`
+}
+
+// RateLimit is the state of the token bucket of a caller after taking a
+// token from it.
+type RateLimit struct {
+	Allowed bool
+	// Limit is the size of the bucket, Remaining the whole tokens left.
+	Limit     int64
+	Remaining int64
+	// RetryAfter is how long until a token is available, Reset until
+	// the bucket is full again.
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Purposes of the one time tokens.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	// PurposeLoginChallenge tokens are not mailed, they carry a login
+	// from the password to the second factor.
+	PurposeLoginChallenge = "login_challenge"
+)
+
+// ErrRefreshReused is returned when a rotated refresh token is presented
+// again, most likely stolen. Its session is revoked.
+var ErrRefreshReused = errors.New("refresh token reused")
+
+// ErrNotFound is returned when a requested record does not exist or
+// has expired.
+var ErrNotFound = errors.New("not found")
+
+// ErrAliasTaken is returned when a bin would get the alias of another.
+var ErrAliasTaken = errors.New("alias already taken")
+
+// ErrNotTextBin is returned when changing the content of a file bin.
+var ErrNotTextBin = errors.New("only the content of text bins can change")
+
 type Store interface {
+	// Ping checks the backend is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the connections of the store.
+	Close() error
 	CreateBin(ctx context.Context, task Bin) (*Bin, error)
 	GetBinByAlias(ctx context.Context, alias string) (*Bin, error)
+	// PeekBinByAlias is GetBinByAlias without counting a click.
+	PeekBinByAlias(ctx context.Context, alias string) (*Bin, error)
+	// CountClic counts a click on bin, once the caller may read it. A bin
+	// deleted meanwhile is not counted.
+	CountClic(ctx context.Context, bin *Bin) error
+	GetBinByID(ctx context.Context, id string) (*Bin, error)
 	GetAllBins(ctx context.Context) ([]Bin, error)
-	GetStats(ctx context.Context) (*Statistics, error)
-	UpdateBin(ctx context.Context, task Bin) (*Bin, error)
+	// GetStats returns the global statistics with the top most viewed
+	// bins.
+	GetStats(ctx context.Context, top int) (*Statistics, error)
+	RecordView(ctx context.Context, event ViewEvent) error
+	GetViewSeries(ctx context.Context, binID string, from, to time.Time, granularity string) (*ViewSeries, error)
+	// UpdateBin applies patch to the bin with the given ID.
+	UpdateBin(ctx context.Context, id string, patch BinPatch) (*Bin, error)
 	DeleteBinByID(ctx context.Context, id string) (*Bin, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	CreateUser(ctx context.Context, user User) (*User, error)
 	GetAllUsers(ctx context.Context) ([]User, error)
-	DropAllUsers(ctx context.Context) error
-}
\ No newline at end of file
+	// UpdateUser replaces the user with the email of user, keeping its
+	// password unless user has a new Password.
+	UpdateUser(ctx context.Context, user User) (*User, error)
+	DeleteUser(ctx context.Context, email string) error
+	// UseTOTPStep marks the time step of a one-time code of email as used.
+	// It reports false when the step was already used.
+	UseTOTPStep(ctx context.Context, email string, step int64) (bool, error)
+	// SetRecoveryCodes replaces the hashes of the recovery codes of email,
+	// nil removing them.
+	SetRecoveryCodes(ctx context.Context, email string, hashes []string) error
+	// UseRecoveryCode removes a recovery code hash of email and returns
+	// how many are left, or ErrNotFound when it is not an unused code.
+	UseRecoveryCode(ctx context.Context, email, hash string) (int64, error)
+	AddBinTags(ctx context.Context, id string, tags []string) (*Bin, error)
+	RemoveBinTags(ctx context.Context, id string, tags []string) (*Bin, error)
+	GetBinsByTag(ctx context.Context, tag string) ([]Bin, error)
+	GetTagCounts(ctx context.Context) (map[string]int64, error)
+	CreateCollection(ctx context.Context, collection Collection) (*Collection, error)
+	GetCollection(ctx context.Context, id string) (*Collection, error)
+	GetCollectionsByOwner(ctx context.Context, owner string) ([]Collection, error)
+	UpdateCollection(ctx context.Context, collection Collection) (*Collection, error)
+	DeleteCollection(ctx context.Context, id string) error
+	AddCollectionBins(ctx context.Context, id string, binIDs []string) (*Collection, error)
+	RemoveCollectionBins(ctx context.Context, id string, binIDs []string) (*Collection, error)
+	CreateUpload(ctx context.Context, upload Upload) (*Upload, error)
+	GetUpload(ctx context.Context, id string) (*Upload, error)
+	UpdateUpload(ctx context.Context, upload Upload) (*Upload, error)
+	DeleteUpload(ctx context.Context, id string) error
+	GetExpiredUploads(ctx context.Context, before time.Time) ([]Upload, error)
+	// LockUpload takes the lock of upload id for the holder token, or
+	// renews it when token already holds it, for ttl. It reports false
+	// when another holder has it.
+	LockUpload(ctx context.Context, id, token string, ttl time.Duration) (bool, error)
+	// UnlockUpload releases the lock of upload id held by token.
+	UnlockUpload(ctx context.Context, id, token string) error
+	// CreateAPIToken saves token with a new secret, returned in clear
+	// only this once.
+	CreateAPIToken(ctx context.Context, token APIToken) (*APIToken, string, error)
+	// GetAPIToken returns the token of secret, or ErrNotFound when it is
+	// unknown, revoked or expired.
+	GetAPIToken(ctx context.Context, secret string) (*APIToken, error)
+	GetAPITokensByOwner(ctx context.Context, owner string) ([]APIToken, error)
+	// DeleteAPIToken revokes the token id of owner.
+	DeleteAPIToken(ctx context.Context, owner, id string) error
+	// CreateSession saves session with a first refresh token, returned in
+	// clear.
+	CreateSession(ctx context.Context, session Session) (*Session, string, error)
+	// RotateSession exchanges the refresh token for a new one. It returns
+	// ErrNotFound for unknown tokens and revoked or expired sessions, and
+	// ErrRefreshReused for tokens already rotated.
+	RotateSession(ctx context.Context, refresh string) (*Session, string, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	GetSessionsByOwner(ctx context.Context, owner string) ([]Session, error)
+	// DeleteSession revokes the session id of owner.
+	DeleteSession(ctx context.Context, owner, id string) error
+	// DeleteSessionsByOwner revokes every session of owner.
+	DeleteSessionsByOwner(ctx context.Context, owner string) error
+	// ReserveLoginAttempt counts an attempt of subject as failed before
+	// it is checked, so concurrent attempts cannot all pass the check,
+	// and returns the failures preceding it. Failures are forgotten
+	// window after the last one.
+	ReserveLoginAttempt(ctx context.Context, subject string, window time.Duration) (*LoginFailures, error)
+	// RefundLoginAttempt uncounts an attempt reserved with failures that
+	// did not fail.
+	RefundLoginAttempt(ctx context.Context, subject string, failures *LoginFailures) error
+	ClearLoginFailures(ctx context.Context, subject string) error
+	// GetUsage returns what owner stores.
+	GetUsage(ctx context.Context, owner string) (*Usage, error)
+	// GetTotalUsage returns what is stored by everyone, read from the
+	// running counters.
+	GetTotalUsage(ctx context.Context) (*Usage, error)
+	// TakeRateToken takes a token from the bucket of key, holding up to
+	// burst tokens and refilled with burst tokens per window.
+	TakeRateToken(ctx context.Context, key string, burst int64, window time.Duration) (*RateLimit, error)
+	// CreateOneTimeToken issues a token for purpose to email, valid for
+	// ttl and revoking the previous one. Only its hash is kept.
+	CreateOneTimeToken(ctx context.Context, purpose, email string, ttl time.Duration) (string, error)
+	// PeekOneTimeToken returns the email a token was issued to without
+	// revoking it, or ErrNotFound when it is unknown, used or expired.
+	PeekOneTimeToken(ctx context.Context, purpose, secret string) (string, error)
+	// ConsumeOneTimeToken returns the email a token was issued to and
+	// revokes it, or ErrNotFound when it is unknown, used or expired.
+	ConsumeOneTimeToken(ctx context.Context, purpose, secret string) (string, error)
+}
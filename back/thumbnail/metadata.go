@@ -0,0 +1,132 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// StripMetadata copies an image from r to w, dropping the EXIF and other
+// embedded metadata of JPEG and PNG files. Other content is copied as is.
+func StripMetadata(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	head, _ := br.Peek(len(pngSignature))
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		return stripJPEG(w, br)
+	case bytes.Equal(head, pngSignature):
+		return stripPNG(w, br)
+	}
+
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP) and APP13 (IPTC) segments found
+// before the image data.
+func stripJPEG(w io.Writer, r *bufio.Reader) error {
+	soi := make([]byte, 2)
+	_, err := io.ReadFull(r, soi)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(soi)
+	if err != nil {
+		return err
+	}
+
+	for {
+		marker := make([]byte, 2)
+		_, err = io.ReadFull(r, marker)
+		if err != nil {
+			return errors.Wrap(err, "truncated jpeg")
+		}
+		if marker[0] != 0xFF {
+			return errors.New("invalid jpeg marker")
+		}
+
+		// the start of scan is followed by the compressed image data
+		if marker[1] == 0xDA {
+			_, err = w.Write(marker)
+			if err == nil {
+				_, err = io.Copy(w, r)
+			}
+			return err
+		}
+
+		size := make([]byte, 2)
+		_, err = io.ReadFull(r, size)
+		if err != nil {
+			return errors.Wrap(err, "truncated jpeg")
+		}
+
+		length := int64(binary.BigEndian.Uint16(size)) - 2
+		if length < 0 {
+			return errors.New("invalid jpeg segment length")
+		}
+
+		if marker[1] == 0xE1 || marker[1] == 0xED {
+			_, err = io.CopyN(io.Discard, r, length)
+			if err != nil {
+				return errors.Wrap(err, "truncated jpeg")
+			}
+			continue
+		}
+
+		_, err = w.Write(append(marker, size...))
+		if err == nil {
+			_, err = io.CopyN(w, r, length)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stripPNG drops the eXIf and textual chunks.
+func stripPNG(w io.Writer, r *bufio.Reader) error {
+	signature := make([]byte, len(pngSignature))
+	_, err := io.ReadFull(r, signature)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(signature)
+	if err != nil {
+		return err
+	}
+
+	for {
+		header := make([]byte, 8)
+		_, err = io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "truncated png")
+		}
+
+		// data followed by its CRC
+		length := int64(binary.BigEndian.Uint32(header[:4])) + 4
+
+		switch string(header[4:]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			_, err = io.CopyN(io.Discard, r, length)
+		default:
+			_, err = w.Write(header)
+			if err == nil {
+				_, err = io.CopyN(w, r, length)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package thumbnail
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// maxPixels refuses to decode images large enough to exhaust memory.
+const maxPixels = 50_000_000
+
+// Sizes are the supported thumbnail sizes, as the length in pixels of
+// the longest side.
+var Sizes = map[string]int{
+	"small":  128,
+	"medium": 320,
+	"large":  640,
+}
+
+// Generate writes to w a JPEG, PNG or GIF thumbnail of the image read from
+// r, fitting in a size x size square and encoded in the source format.
+// The image is never upscaled. It returns the format name.
+func Generate(w io.Writer, r io.ReadSeeker, size int) (string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt read image header")
+	}
+
+	if config.Width*config.Height > maxPixels {
+		return "", errors.Errorf("image too large (%dx%d)", config.Width, config.Height)
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt decode image")
+	}
+
+	width, height := fit(config.Width, config.Height, size)
+	dst := resize(src, width, height)
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(w, dst, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(w, dst)
+	case "gif":
+		err = gif.Encode(w, dst, nil)
+	default:
+		err = errors.Errorf("unsupported image format %s", format)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "couldnt encode thumbnail")
+	}
+
+	return format, nil
+}
+
+// fit scales width and height down so the longest side is at most size.
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+
+	return max(1, width*size/height), size
+}
+
+// resize scales src down to width x height, averaging every source pixel
+// covered by a destination pixel.
+func resize(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
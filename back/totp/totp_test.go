@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the test vectors of RFC 6238, the ASCII
+// string 12345678901234567890, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA1 vectors of RFC 6238 appendix B, whose
+// 8 digit codes end with the 6 digits authenticator apps show.
+func TestCodeRFC6238(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		want := v.code[len(v.code)-Digits:]
+
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", v.unix, err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+
+		// lowercase secrets, as some apps show them, give the same codes
+		got, err = Code(strings.ToLower(rfcSecret), Step(time.Unix(v.unix, 0)))
+		if err != nil || got != want {
+			t.Errorf("Code of lowercase secret at %d = %s, %v, want %s", v.unix, got, err, want)
+		}
+	}
+}
+
+func TestValidateDrift(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := Step(at)
+
+	for _, drift := range []int64{-1, 0, 1} {
+		code, err := Code(rfcSecret, step+drift)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := Validate(rfcSecret, code, at)
+		if !ok || got != step+drift {
+			t.Errorf("Validate of code %+d step = %d, %v, want %d, true", drift, got, ok, step+drift)
+		}
+	}
+
+	code, err := Code(rfcSecret, step+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, code, at); ok {
+		t.Errorf("Validate accepted a code 2 steps ahead")
+	}
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"pastebin/blob"
+)
+
+// tracedStorage wraps every blob operation in a span.
+type tracedStorage struct {
+	next blob.Storage
+}
+
+// NewStorage wraps blobs so its operations are traced.
+func NewStorage(blobs blob.Storage) blob.Storage {
+	return &tracedStorage{next: blobs}
+}
+
+func startBlobSpan(ctx context.Context, op, key string) (context.Context, trace.Span) {
+	return Start(ctx, "blob."+op, trace.WithAttributes(attribute.String("blob.key", key)))
+}
+
+func (s *tracedStorage) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	ctx, span := startBlobSpan(ctx, "Put", key)
+	n, err := s.next.Put(ctx, key, r)
+	span.SetAttributes(attribute.Int64("blob.bytes", n))
+	End(span, err)
+
+	return n, err
+}
+
+func (s *tracedStorage) Append(ctx context.Context, key string, r io.Reader) (int64, error) {
+	ctx, span := startBlobSpan(ctx, "Append", key)
+	n, err := s.next.Append(ctx, key, r)
+	span.SetAttributes(attribute.Int64("blob.bytes", n))
+	End(span, err)
+
+	return n, err
+}
+
+// Open only traces opening the blob, reading it is left to the caller.
+func (s *tracedStorage) Open(ctx context.Context, key string) (blob.Object, error) {
+	ctx, span := startBlobSpan(ctx, "Open", key)
+	obj, err := s.next.Open(ctx, key)
+	End(span, err)
+
+	return obj, err
+}
+
+func (s *tracedStorage) Delete(ctx context.Context, key string) error {
+	ctx, span := startBlobSpan(ctx, "Delete", key)
+	err := s.next.Delete(ctx, key)
+	End(span, err)
+
+	return err
+}
+
+func (s *tracedStorage) Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	ctx, span := Start(ctx, "blob.Walk")
+	err := s.next.Walk(ctx, fn)
+	End(span, err)
+
+	return err
+}
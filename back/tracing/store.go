@@ -0,0 +1,498 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"pastebin/store"
+)
+
+// tracedStore wraps every call to the Store in a span. A missing record
+// is not an error.
+type tracedStore struct {
+	next store.Store
+}
+
+// NewStore wraps svc so its calls are traced.
+func NewStore(svc store.Store) store.Store {
+	return &tracedStore{next: svc}
+}
+
+func startStoreSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("store.method", method)),
+	)
+}
+
+func endStoreSpan(span trace.Span, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		err = nil
+	}
+
+	End(span, err)
+}
+
+func (s *tracedStore) Ping(ctx context.Context) error {
+	ctx, span := startStoreSpan(ctx, "Ping")
+	err := s.next.Ping(ctx)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) Close() error {
+	return s.next.Close()
+}
+
+func (s *tracedStore) CreateBin(ctx context.Context, bin store.Bin) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "CreateBin")
+	res, err := s.next.CreateBin(ctx, bin)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "GetBinByAlias")
+	res, err := s.next.GetBinByAlias(ctx, alias)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) PeekBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "PeekBinByAlias")
+	res, err := s.next.PeekBinByAlias(ctx, alias)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) CountClic(ctx context.Context, bin *store.Bin) error {
+	ctx, span := startStoreSpan(ctx, "CountClic")
+	err := s.next.CountClic(ctx, bin)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) GetBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "GetBinByID")
+	res, err := s.next.GetBinByID(ctx, id)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetAllBins(ctx context.Context) ([]store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "GetAllBins")
+	res, err := s.next.GetAllBins(ctx)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetStats(ctx context.Context, top int) (*store.Statistics, error) {
+	ctx, span := startStoreSpan(ctx, "GetStats")
+	res, err := s.next.GetStats(ctx, top)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) RecordView(ctx context.Context, event store.ViewEvent) error {
+	ctx, span := startStoreSpan(ctx, "RecordView")
+	err := s.next.RecordView(ctx, event)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) GetViewSeries(ctx context.Context, binID string, from, to time.Time, granularity string) (*store.ViewSeries, error) {
+	ctx, span := startStoreSpan(ctx, "GetViewSeries")
+	res, err := s.next.GetViewSeries(ctx, binID, from, to, granularity)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) UpdateBin(ctx context.Context, id string, patch store.BinPatch) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "UpdateBin")
+	res, err := s.next.UpdateBin(ctx, id, patch)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteBinByID(ctx context.Context, id string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "DeleteBinByID")
+	res, err := s.next.DeleteBinByID(ctx, id)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetUserByEmail(ctx context.Context, email string) (*store.User, error) {
+	ctx, span := startStoreSpan(ctx, "GetUserByEmail")
+	res, err := s.next.GetUserByEmail(ctx, email)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) CreateUser(ctx context.Context, user store.User) (*store.User, error) {
+	ctx, span := startStoreSpan(ctx, "CreateUser")
+	res, err := s.next.CreateUser(ctx, user)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetAllUsers(ctx context.Context) ([]store.User, error) {
+	ctx, span := startStoreSpan(ctx, "GetAllUsers")
+	res, err := s.next.GetAllUsers(ctx)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) UpdateUser(ctx context.Context, user store.User) (*store.User, error) {
+	ctx, span := startStoreSpan(ctx, "UpdateUser")
+	res, err := s.next.UpdateUser(ctx, user)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteUser(ctx context.Context, email string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteUser")
+	err := s.next.DeleteUser(ctx, email)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	ctx, span := startStoreSpan(ctx, "UseTOTPStep")
+	res, err := s.next.UseTOTPStep(ctx, email, step)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) SetRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	ctx, span := startStoreSpan(ctx, "SetRecoveryCodes")
+	err := s.next.SetRecoveryCodes(ctx, email, hashes)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) UseRecoveryCode(ctx context.Context, email, hash string) (int64, error) {
+	ctx, span := startStoreSpan(ctx, "UseRecoveryCode")
+	res, err := s.next.UseRecoveryCode(ctx, email, hash)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) AddBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "AddBinTags")
+	res, err := s.next.AddBinTags(ctx, id, tags)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) RemoveBinTags(ctx context.Context, id string, tags []string) (*store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "RemoveBinTags")
+	res, err := s.next.RemoveBinTags(ctx, id, tags)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetBinsByTag(ctx context.Context, tag string) ([]store.Bin, error) {
+	ctx, span := startStoreSpan(ctx, "GetBinsByTag")
+	res, err := s.next.GetBinsByTag(ctx, tag)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetTagCounts(ctx context.Context) (map[string]int64, error) {
+	ctx, span := startStoreSpan(ctx, "GetTagCounts")
+	res, err := s.next.GetTagCounts(ctx)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) CreateCollection(ctx context.Context, collection store.Collection) (*store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "CreateCollection")
+	res, err := s.next.CreateCollection(ctx, collection)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetCollection(ctx context.Context, id string) (*store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "GetCollection")
+	res, err := s.next.GetCollection(ctx, id)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetCollectionsByOwner(ctx context.Context, owner string) ([]store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "GetCollectionsByOwner")
+	res, err := s.next.GetCollectionsByOwner(ctx, owner)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) UpdateCollection(ctx context.Context, collection store.Collection) (*store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "UpdateCollection")
+	res, err := s.next.UpdateCollection(ctx, collection)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteCollection(ctx context.Context, id string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteCollection")
+	err := s.next.DeleteCollection(ctx, id)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) AddCollectionBins(ctx context.Context, id string, binIDs []string) (*store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "AddCollectionBins")
+	res, err := s.next.AddCollectionBins(ctx, id, binIDs)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) RemoveCollectionBins(ctx context.Context, id string, binIDs []string) (*store.Collection, error) {
+	ctx, span := startStoreSpan(ctx, "RemoveCollectionBins")
+	res, err := s.next.RemoveCollectionBins(ctx, id, binIDs)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) CreateUpload(ctx context.Context, upload store.Upload) (*store.Upload, error) {
+	ctx, span := startStoreSpan(ctx, "CreateUpload")
+	res, err := s.next.CreateUpload(ctx, upload)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetUpload(ctx context.Context, id string) (*store.Upload, error) {
+	ctx, span := startStoreSpan(ctx, "GetUpload")
+	res, err := s.next.GetUpload(ctx, id)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) UpdateUpload(ctx context.Context, upload store.Upload) (*store.Upload, error) {
+	ctx, span := startStoreSpan(ctx, "UpdateUpload")
+	res, err := s.next.UpdateUpload(ctx, upload)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteUpload(ctx context.Context, id string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteUpload")
+	err := s.next.DeleteUpload(ctx, id)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) GetExpiredUploads(ctx context.Context, before time.Time) ([]store.Upload, error) {
+	ctx, span := startStoreSpan(ctx, "GetExpiredUploads")
+	res, err := s.next.GetExpiredUploads(ctx, before)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) LockUpload(ctx context.Context, id, token string, ttl time.Duration) (bool, error) {
+	ctx, span := startStoreSpan(ctx, "LockUpload")
+	res, err := s.next.LockUpload(ctx, id, token, ttl)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) UnlockUpload(ctx context.Context, id, token string) error {
+	ctx, span := startStoreSpan(ctx, "UnlockUpload")
+	err := s.next.UnlockUpload(ctx, id, token)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) CreateAPIToken(ctx context.Context, token store.APIToken) (*store.APIToken, string, error) {
+	ctx, span := startStoreSpan(ctx, "CreateAPIToken")
+	res, secret, err := s.next.CreateAPIToken(ctx, token)
+	endStoreSpan(span, err)
+
+	return res, secret, err
+}
+
+func (s *tracedStore) GetAPIToken(ctx context.Context, secret string) (*store.APIToken, error) {
+	ctx, span := startStoreSpan(ctx, "GetAPIToken")
+	res, err := s.next.GetAPIToken(ctx, secret)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetAPITokensByOwner(ctx context.Context, owner string) ([]store.APIToken, error) {
+	ctx, span := startStoreSpan(ctx, "GetAPITokensByOwner")
+	res, err := s.next.GetAPITokensByOwner(ctx, owner)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteAPIToken(ctx context.Context, owner, id string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteAPIToken")
+	err := s.next.DeleteAPIToken(ctx, owner, id)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) CreateSession(ctx context.Context, session store.Session) (*store.Session, string, error) {
+	ctx, span := startStoreSpan(ctx, "CreateSession")
+	res, secret, err := s.next.CreateSession(ctx, session)
+	endStoreSpan(span, err)
+
+	return res, secret, err
+}
+
+func (s *tracedStore) RotateSession(ctx context.Context, refresh string) (*store.Session, string, error) {
+	ctx, span := startStoreSpan(ctx, "RotateSession")
+	res, secret, err := s.next.RotateSession(ctx, refresh)
+	endStoreSpan(span, err)
+
+	return res, secret, err
+}
+
+func (s *tracedStore) GetSession(ctx context.Context, id string) (*store.Session, error) {
+	ctx, span := startStoreSpan(ctx, "GetSession")
+	res, err := s.next.GetSession(ctx, id)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetSessionsByOwner(ctx context.Context, owner string) ([]store.Session, error) {
+	ctx, span := startStoreSpan(ctx, "GetSessionsByOwner")
+	res, err := s.next.GetSessionsByOwner(ctx, owner)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) DeleteSession(ctx context.Context, owner, id string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteSession")
+	err := s.next.DeleteSession(ctx, owner, id)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) DeleteSessionsByOwner(ctx context.Context, owner string) error {
+	ctx, span := startStoreSpan(ctx, "DeleteSessionsByOwner")
+	err := s.next.DeleteSessionsByOwner(ctx, owner)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) ReserveLoginAttempt(ctx context.Context, subject string, window time.Duration) (*store.LoginFailures, error) {
+	ctx, span := startStoreSpan(ctx, "ReserveLoginAttempt")
+	res, err := s.next.ReserveLoginAttempt(ctx, subject, window)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) RefundLoginAttempt(ctx context.Context, subject string, failures *store.LoginFailures) error {
+	ctx, span := startStoreSpan(ctx, "RefundLoginAttempt")
+	err := s.next.RefundLoginAttempt(ctx, subject, failures)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) ClearLoginFailures(ctx context.Context, subject string) error {
+	ctx, span := startStoreSpan(ctx, "ClearLoginFailures")
+	err := s.next.ClearLoginFailures(ctx, subject)
+	endStoreSpan(span, err)
+
+	return err
+}
+
+func (s *tracedStore) GetUsage(ctx context.Context, owner string) (*store.Usage, error) {
+	ctx, span := startStoreSpan(ctx, "GetUsage")
+	res, err := s.next.GetUsage(ctx, owner)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) GetTotalUsage(ctx context.Context) (*store.Usage, error) {
+	ctx, span := startStoreSpan(ctx, "GetTotalUsage")
+	res, err := s.next.GetTotalUsage(ctx)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) TakeRateToken(ctx context.Context, key string, burst int64, window time.Duration) (*store.RateLimit, error) {
+	ctx, span := startStoreSpan(ctx, "TakeRateToken")
+	res, err := s.next.TakeRateToken(ctx, key, burst, window)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) CreateOneTimeToken(ctx context.Context, purpose, email string, ttl time.Duration) (string, error) {
+	ctx, span := startStoreSpan(ctx, "CreateOneTimeToken")
+	res, err := s.next.CreateOneTimeToken(ctx, purpose, email, ttl)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) PeekOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	ctx, span := startStoreSpan(ctx, "PeekOneTimeToken")
+	res, err := s.next.PeekOneTimeToken(ctx, purpose, secret)
+	endStoreSpan(span, err)
+
+	return res, err
+}
+
+func (s *tracedStore) ConsumeOneTimeToken(ctx context.Context, purpose, secret string) (string, error) {
+	ctx, span := startStoreSpan(ctx, "ConsumeOneTimeToken")
+	res, err := s.next.ConsumeOneTimeToken(ctx, purpose, secret)
+	endStoreSpan(span, err)
+
+	return res, err
+}
@@ -0,0 +1,202 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"pastebin/blob"
+	"pastebin/store"
+)
+
+// collector is an OTLP/HTTP receiver keeping the spans it is sent.
+type collector struct {
+	mu       sync.Mutex
+	spans    []*tracepb.Span
+	services []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &coltracepb.ExportTraceServiceRequest{}
+	err = proto.Unmarshal(body, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.GetResource().GetAttributes() {
+			if attr.Key == "service.name" {
+				c.services = append(c.services, attr.GetValue().GetStringValue())
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+// span returns the span named name, failing the test without one.
+func (c *collector) span(t *testing.T, name string) *tracepb.Span {
+	t.Helper()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+
+	names := []string{}
+	for _, span := range c.spans {
+		names = append(names, span.Name)
+	}
+	t.Fatalf("no span %q among %v", name, names)
+
+	return nil
+}
+
+// binStore is a Store holding one bin, the other methods are not called.
+type binStore struct {
+	store.Store
+	bin store.Bin
+}
+
+func (s *binStore) GetBinByAlias(ctx context.Context, alias string) (*store.Bin, error) {
+	if alias != s.bin.Alias {
+		return nil, store.ErrNotFound
+	}
+
+	bin := s.bin
+	return &bin, nil
+}
+
+func TestSetupExportsSpans(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	col := &collector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	ctx := context.Background()
+	shutdown, err := Setup(ctx, Options{
+		Endpoint:    strings.TrimPrefix(srv.URL, "http://"),
+		Insecure:    true,
+		SampleRatio: 1,
+		ServiceName: "pastebin-test",
+	})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	disk, err := blob.NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskStorage: %v", err)
+	}
+	_, err = disk.Put(ctx, "hello", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	svc := NewStore(&binStore{bin: store.Bin{ID: "1", Alias: "greeting", Contain: "hello"}})
+	blobs := NewStorage(disk)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/bins/{alias}", func(w http.ResponseWriter, r *http.Request) {
+		bin, err := svc.GetBinByAlias(r.Context(), chi.URLParam(r, "alias"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := blobs.Open(r.Context(), bin.Contain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		io.Copy(w, f)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/bins/greeting", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentSpanID+"-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Fatalf("GET /bins/greeting answered %d %q", rec.Code, rec.Body.String())
+	}
+
+	// a missing bin is not an error of the store span
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bins/missing", nil))
+
+	err = shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	server := col.span(t, "GET /bins/{alias}")
+	if got := hex.EncodeToString(server.TraceId); got != traceID {
+		t.Errorf("server span trace %s, want the incoming %s", got, traceID)
+	}
+	if got := hex.EncodeToString(server.ParentSpanId); got != parentSpanID {
+		t.Errorf("server span parent %s, want the incoming %s", got, parentSpanID)
+	}
+	if server.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("server span kind %v", server.Kind)
+	}
+
+	for _, name := range []string{"store.GetBinByAlias", "blob.Open"} {
+		child := col.span(t, name)
+		if hex.EncodeToString(child.TraceId) != traceID {
+			t.Errorf("%s is not in the incoming trace", name)
+		}
+		if hex.EncodeToString(child.ParentSpanId) != hex.EncodeToString(server.SpanId) {
+			t.Errorf("%s is not a child of the server span", name)
+		}
+	}
+
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	for _, span := range col.spans {
+		if span.Name == "store.GetBinByAlias" && span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+			t.Errorf("store span of a missing bin has an error status")
+		}
+	}
+	if len(col.services) == 0 || col.services[0] != "pastebin-test" {
+		t.Errorf("spans exported for services %v, want pastebin-test", col.services)
+	}
+}